@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/ptrngy/xsens_rotate/pkg/calibration"
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+	"github.com/ptrngy/xsens_rotate/pkg/parser"
+	"github.com/ptrngy/xsens_rotate/pkg/processing"
+	"github.com/ptrngy/xsens_rotate/pkg/recording"
+)
+
+type config struct {
+	Outfile  string
+	Profiles string
+	Infiles  []string
+}
+
+var c config
+
+func main() {
+	flag.StringVar(&c.Outfile, "output", "accelero.json", "Accelerometer calibration file to write")
+	flag.StringVar(&c.Profiles, "profiles", "", "Directory of calibration profiles, the calibration is also stored into the profile of the device")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: calibrate [-output file] log...")
+		fmt.Fprintln(flag.CommandLine.Output(), "The logs are recorded with the sensor resting in six or more orientations, each axis pointing up and down.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	c.Infiles = flag.Args()
+	if len(c.Infiles) == 0 {
+		log.Fatalf("no log file defined")
+	}
+
+	deviceid := ""
+	poses := make([]measurement.Vector3D, 0)
+	for idx, infile := range c.Infiles {
+		p := parser.NewXSensLogParser(infile)
+		err := p.Parse()
+		if err != nil {
+			log.Fatalf("unable to parse file %s: %s\n", infile, err.Error())
+		}
+
+		r, err := p.GetRecording()
+		if err != nil {
+			log.Fatalf("unable to parse file %s: %s\n", infile, err.Error())
+		}
+
+		// Logs without a device ID can not be told apart from those of another device either
+		if idx > 0 && r.DeviceID != deviceid {
+			log.Fatalf("logs of different devices: %q and %q\n", deviceid, r.DeviceID)
+		}
+		deviceid = r.DeviceID
+
+		found := calibration.FindPoses(r.Vectors[recording.Accelero], r.Vectors[recording.Gyro], r.SamplingFrequency(), calibration.DefaultStillnessConfig())
+
+		fmt.Printf("%s: %d static poses\n", infile, len(found))
+		for _, pose := range found {
+			fmt.Printf("  %.4f %.4f %.4f (|a| = %.4f)\n", pose.X, pose.Y, pose.Z, pose.Norm())
+		}
+
+		poses = append(poses, found...)
+	}
+
+	if c.Profiles != "" && deviceid == "" {
+		log.Fatalf("unable to store calibration profile: the logs do not name the device\n")
+	}
+
+	cal, err := calibration.FitAccelero(poses)
+	if err != nil {
+		log.Fatalf("unable to calibrate accelerometer: %s\n", err.Error())
+	}
+	fmt.Print(cal)
+
+	err = cal.Save(c.Outfile)
+	if err != nil {
+		log.Fatalf("unable to write calibration file: %s\n", err.Error())
+	}
+	fmt.Println("Calibration written to ", c.Outfile)
+
+	if c.Profiles != "" {
+		store := calibration.NewProfileStore(c.Profiles)
+		profile, err := processing.LoadProfile(store, deviceid)
+		if err != nil {
+			log.Fatalf("unable to load calibration profile: %s\n", err.Error())
+		}
+		if profile == nil {
+			profile = calibration.NewProfile(deviceid)
+		}
+
+		profile.Accelero = cal
+		err = store.Save(*profile)
+		if err != nil {
+			log.Fatalf("unable to save calibration profile: %s\n", err.Error())
+		}
+		fmt.Println("Calibration profile saved for device ", deviceid)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ptrngy/xsens_rotate/pkg/calibration"
+	"github.com/ptrngy/xsens_rotate/pkg/evaluation"
+	"github.com/ptrngy/xsens_rotate/pkg/imu"
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+	"github.com/ptrngy/xsens_rotate/pkg/parser"
+	"github.com/ptrngy/xsens_rotate/pkg/processing"
+	"github.com/ptrngy/xsens_rotate/pkg/recording"
+)
+
+type config struct {
+	Configs      string
+	Frame        string
+	Threshold    float64
+	AlignHeading bool
+	Lenient      bool
+	Profiles     string
+	Infiles      []string
+}
+
+var c config
+
+func main() {
+	flag.StringVar(&c.Configs, "configs", strings.Join(imu.FilterNames(), ";"), "Filter configurations separated by semicolons, e.g. madgwick;mahony:kp=1,ki=0")
+	flag.StringVar(&c.Frame, "frame", "", "Coordinate frame of the logs (ENU, NED, NWU), overrides the one named in the header")
+	flag.Float64Var(&c.Threshold, "threshold", 5.0, "Angular error in degrees the filter is converged below")
+	flag.BoolVar(&c.AlignHeading, "alignheading", false, "Remove the mean heading offset between filter and chip before computing the errors")
+	flag.BoolVar(&c.Lenient, "lenient", false, "Skip malformed rows of the logs instead of failing")
+	flag.StringVar(&c.Profiles, "profiles", "", "Directory of calibration profiles, the profile matching the DeviceId of each log is applied")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: evaluate [flags] log...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	c.Infiles = flag.Args()
+	if len(c.Infiles) == 0 {
+		log.Fatalf("no log file defined")
+	}
+
+	configs := make([]imu.FilterConfig, 0)
+	for _, s := range strings.Split(c.Configs, ";") {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+
+		fc, err := imu.ParseFilterConfig(s)
+		if err != nil {
+			log.Fatalf("invalid filter configuration: %s\n", err.Error())
+		}
+		configs = append(configs, fc)
+	}
+
+	cfg := evaluation.DefaultConfig()
+	cfg.ConvergenceThreshold = c.Threshold * math.Pi / 180.0
+	cfg.RemoveHeadingOffset = c.AlignHeading
+
+	var store *calibration.ProfileStore
+	if c.Profiles != "" {
+		store = calibration.NewProfileStore(c.Profiles)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "Log\tFilter\tConvergence [s]\tRMS [deg]\tMax [deg]\tRoll [deg]\tPitch [deg]\tYaw [deg]\tOffset [deg]\tDrift [deg/min]\t")
+
+	for _, infile := range c.Infiles {
+		p := parser.NewXSensLogParser(infile)
+		p.Lenient = c.Lenient
+		if c.Frame != "" {
+			frame, err := measurement.ParseFrame(c.Frame)
+			if err != nil {
+				log.Fatalf("invalid frame: %s\n", err.Error())
+			}
+			p.Frame = frame
+		}
+
+		err := p.Parse()
+		if err != nil {
+			log.Fatalf("unable to parse file %s: %s\n", infile, err.Error())
+		}
+
+		if p.Dropped.Rows > 0 {
+			fmt.Fprintf(os.Stderr, "%s: %s", infile, p.Dropped)
+		}
+
+		rec, err := p.GetRecording()
+		if err != nil {
+			log.Fatalf("unable to parse file %s: %s\n", infile, err.Error())
+		}
+
+		if rec.Frame == measurement.FrameUnknown {
+			fmt.Fprintf(os.Stderr, "Warning: coordinate frame of %s is unknown, it is taken as NWU (set it with -frame)\n", infile)
+			rec.AssumeFrame(measurement.FrameNWU)
+		}
+
+		_, err = processing.ApplyProfile(rec, store)
+		if err != nil {
+			log.Fatalf("unable to apply calibration profile to file %s: %s\n", infile, err.Error())
+		}
+
+		for _, fc := range configs {
+			err = recording.Process(rec, processing.NewFilterStage(fc.Name, fc.Params))
+			if err != nil {
+				log.Fatalf("unable to run software filter %s: %s\n", fc, err.Error())
+			}
+
+			r, err := processing.EvaluateAgainstChip(rec, recording.IMUOrientation, cfg)
+			if err != nil {
+				log.Fatalf("unable to evaluate software filter %s: %s\n", fc, err.Error())
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%.2f\t%.3f\t%.3f\t%.3f\t%.3f\t%.3f\t%.3f\t%.3f\t\n",
+				infile, fc, r.ConvergenceTime, r.AngleRMS*180.0/math.Pi, r.AngleMax*180.0/math.Pi,
+				r.RollRMSE*180.0/math.Pi, r.PitchRMSE*180.0/math.Pi, r.YawRMSE*180.0/math.Pi,
+				r.HeadingOffset*180.0/math.Pi, r.DriftRate*60.0*180.0/math.Pi)
+		}
+	}
+
+	w.Flush()
+}
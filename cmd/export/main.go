@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+
+	"github.com/ptrngy/xsens_rotate/pkg/calibration"
+	"github.com/ptrngy/xsens_rotate/pkg/imu"
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+	"github.com/ptrngy/xsens_rotate/pkg/parser"
+	"github.com/ptrngy/xsens_rotate/pkg/processing"
+)
+
+type config struct {
+	Infile   string
+	Outfile  string
+	Filter   string
+	Frame    string
+	Profiles string
+	Lenient  bool
+}
+
+var c config
+
+func main() {
+	flag.StringVar(&c.Outfile, "output", "", "Output file, standard output if not set")
+	flag.StringVar(&c.Filter, "filter", processing.DefaultFilter, "Filter configuration, e.g. madgwick or mahony:kp=1,ki=0")
+	flag.StringVar(&c.Frame, "frame", "", "Coordinate frame of the log (ENU, NED, NWU), overrides the one named in the header")
+	flag.StringVar(&c.Profiles, "profiles", "", "Directory of the device calibration profiles")
+	flag.BoolVar(&c.Lenient, "lenient", false, "Skip malformed rows of the log instead of failing")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: export [flags] log")
+		fmt.Fprintln(flag.CommandLine.Output(), "Writes the chip and the software filter orientation in degrees as a tab-separated file.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		log.Fatalf("no log file defined")
+	}
+	c.Infile = flag.Arg(0)
+
+	fc, err := imu.ParseFilterConfig(c.Filter)
+	if err != nil {
+		log.Fatalf("invalid filter configuration: %s\n", err.Error())
+	}
+
+	frame := measurement.FrameUnknown
+	if c.Frame != "" {
+		frame, err = measurement.ParseFrame(c.Frame)
+		if err != nil {
+			log.Fatalf("invalid frame: %s\n", err.Error())
+		}
+	}
+
+	in, err := os.Open(c.Infile)
+	if err != nil {
+		log.Fatalf("unable to open file %s: %s\n", c.Infile, err.Error())
+	}
+	defer in.Close()
+
+	out := os.Stdout
+	if c.Outfile != "" {
+		out, err = os.Create(c.Outfile)
+		if err != nil {
+			log.Fatalf("unable to create output file: %s\n", err.Error())
+		}
+		defer out.Close()
+	}
+
+	// The log is processed row by row, its size is not limited by the memory
+	err = export(in, out, frame, processing.NewFilterStage(fc.Name, fc.Params))
+	if err != nil {
+		log.Fatalf("unable to export file %s: %s\n", c.Infile, err.Error())
+	}
+}
+
+// export streams the log through the calibrations and the software filter, one row per sample: index, time in
+// seconds, chip and filter Euler angles in degrees
+func export(in io.Reader, out io.Writer, frame measurement.Frame, filter *processing.FilterStage) error {
+	reader, err := parser.NewSampleReader(in, frame)
+	if err != nil {
+		return err
+	}
+	reader.File = c.Infile
+	reader.Lenient = c.Lenient
+
+	// Rows are parsed on reading, the assumed frame applies to all of them
+	if reader.Frame == measurement.FrameUnknown {
+		fmt.Fprintln(os.Stderr, "Warning: coordinate frame of the log is unknown, it is taken as NWU (set it with -frame)")
+		reader.Frame = measurement.FrameNWU
+	}
+
+	var store *calibration.ProfileStore
+	if c.Profiles != "" {
+		store = calibration.NewProfileStore(c.Profiles)
+	}
+	profile, err := processing.LoadProfile(store, reader.Metadata.DeviceID)
+	if err != nil {
+		return err
+	}
+
+	calibrator := processing.NewCalibrationStage()
+	if profile != nil {
+		calibrator.ApplyProfile(profile)
+	}
+
+	b := bufio.NewWriter(out)
+	_, err = b.WriteString("Sample\tTime\tRoll\tPitch\tYaw\tIMU_Roll\tIMU_Pitch\tIMU_Yaw\n")
+	if err != nil {
+		return err
+	}
+
+	var fusion *processing.Fusion
+	for {
+		s, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		// The first sample interval stands in for the nominal sampling frequency
+		if fusion == nil {
+			fusion, err = filter.NewFusion(1.0/s.DeltaT, reader.Frame)
+			if err != nil {
+				return err
+			}
+		}
+
+		gyro, accelero, magneto := calibrator.Apply(s.Gyro, s.Accelero, s.Magneto)
+		imuori := fusion.Update(gyro, accelero, magneto, s.DeltaT).GetAsEuler()
+		chip := calibrator.AlignOrientation(s.EulerOri.GetAsQuaternion()).GetAsEuler()
+
+		_, err = fmt.Fprintf(b, "%d\t%.4f\t%.4f\t%.4f\t%.4f\t%.4f\t%.4f\t%.4f\n", s.Index, s.Timestamp,
+			chip.Roll*180.0/math.Pi, chip.Pitch*180.0/math.Pi, chip.Yaw*180.0/math.Pi,
+			imuori.Roll*180.0/math.Pi, imuori.Pitch*180.0/math.Pi, imuori.Yaw*180.0/math.Pi)
+		if err != nil {
+			return err
+		}
+	}
+
+	if reader.Dropped.Rows > 0 {
+		fmt.Fprint(os.Stderr, reader.Dropped)
+	}
+
+	return b.Flush()
+}
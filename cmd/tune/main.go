@@ -0,0 +1,235 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/ptrngy/xsens_rotate/pkg/calibration"
+	"github.com/ptrngy/xsens_rotate/pkg/evaluation"
+	"github.com/ptrngy/xsens_rotate/pkg/imu"
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+	"github.com/ptrngy/xsens_rotate/pkg/parser"
+	"github.com/ptrngy/xsens_rotate/pkg/processing"
+	"github.com/ptrngy/xsens_rotate/pkg/recording"
+	"github.com/ptrngy/xsens_rotate/pkg/tuning"
+)
+
+type config struct {
+	Filter       string
+	Space        string
+	Method       string
+	Iterations   int
+	Workers      int
+	Reference    string
+	Frame        string
+	AlignHeading bool
+	Table        string
+	Best         string
+	Profiles     string
+	Infiles      []string
+}
+
+var c config
+
+func main() {
+	flag.StringVar(&c.Filter, "filter", processing.DefaultFilter, "Software orientation filter to tune, one of: "+strings.Join(imu.FilterNames(), ", "))
+	flag.StringVar(&c.Space, "space", "beta=0.05:2:8", "Parameter ranges as name=min:max:steps, a single value fixes the parameter")
+	flag.StringVar(&c.Method, "method", "grid", "Search method: grid or neldermead")
+	flag.IntVar(&c.Iterations, "iterations", 50, "Iterations of the Nelder-Mead search")
+	flag.IntVar(&c.Workers, "workers", runtime.NumCPU(), "Candidates evaluated in parallel")
+	flag.StringVar(&c.Reference, "reference", "euler", "Chip orientation output to compare with: euler (Roll/Pitch/Yaw) or quat (Quat_q*)")
+	flag.StringVar(&c.Frame, "frame", "", "Coordinate frame of the logs (ENU, NED, NWU), overrides the one named in the header")
+	flag.BoolVar(&c.AlignHeading, "alignheading", false, "Remove the mean heading offset between filter and chip before computing the error")
+	flag.StringVar(&c.Table, "table", "output/tune.txt", "Tab-separated error-vs-parameter table to write")
+	flag.StringVar(&c.Profiles, "profiles", "", "Directory of calibration profiles, the profile matching the DeviceId of each log is applied")
+	flag.StringVar(&c.Best, "best", "", "File to write the best filter configuration to, e.g. for evaluate -configs")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: tune [flags] log...")
+		fmt.Fprintln(flag.CommandLine.Output(), "The error of a candidate is the mean over the logs of the RMS angular error against the chip orientation.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	c.Infiles = flag.Args()
+	if len(c.Infiles) == 0 {
+		log.Fatalf("no log file defined")
+	}
+
+	space, err := tuning.ParseSpace(c.Space)
+	if err != nil {
+		log.Fatalf("invalid parameter space: %s\n", err.Error())
+	}
+
+	logs := make([]*recording.Recording, 0, len(c.Infiles))
+	references := make([]string, 0, len(c.Infiles))
+	for _, infile := range c.Infiles {
+		r, reference, err := load(infile)
+		if err != nil {
+			log.Fatalf("unable to load file %s: %s\n", infile, err.Error())
+		}
+		logs = append(logs, r)
+		references = append(references, reference)
+	}
+
+	cfg := evaluation.DefaultConfig()
+	cfg.RemoveHeadingOffset = c.AlignHeading
+
+	// Candidates only read the recordings, the filter outputs are merged into a derived recording per run
+	objective := func(params map[string]float64) ([]float64, error) {
+		errors := make([]float64, 0, len(logs))
+
+		for i, rec := range logs {
+			derived, err := processing.NewFilterStage(c.Filter, params).Process(rec)
+			if err != nil {
+				return nil, err
+			}
+
+			reference, err := rec.GetOrientations(references[i])
+			if err != nil {
+				return nil, err
+			}
+
+			r, err := evaluation.Evaluate(reference, derived.Orientations[recording.IMUOrientation], rec.Timestamps, cfg)
+			if err != nil {
+				return nil, err
+			}
+
+			// The whole series counts, slow convergence is penalised
+			rms := 0.0
+			for _, e := range r.AngularError {
+				rms += e * e / float64(len(r.AngularError))
+			}
+			errors = append(errors, math.Sqrt(rms))
+		}
+
+		return errors, nil
+	}
+
+	var candidates []tuning.Candidate
+	switch c.Method {
+	case "grid":
+		candidates = tuning.GridSearch(space, objective, c.Workers)
+	case "neldermead":
+		candidates = tuning.NelderMead(space, objective, c.Workers, c.Iterations)
+	default:
+		log.Fatalf("unknown search method: %s\n", c.Method)
+	}
+
+	best := candidates[0]
+	if best.Err != nil {
+		log.Fatalf("no candidate could be evaluated: %s\n", best.Err.Error())
+	}
+
+	err = writeTable(space, candidates)
+	if err != nil {
+		log.Fatalf("unable to write table: %s\n", err.Error())
+	}
+
+	result := imu.FilterConfig{Name: c.Filter, Params: best.Params}
+	fmt.Printf("Evaluated %d candidates, best: %s\n", len(candidates), result)
+	for i, infile := range c.Infiles {
+		fmt.Printf("  %s: RMS angular error %.3f degree\n", infile, best.Errors[i]*180.0/math.Pi)
+	}
+
+	if c.Best != "" {
+		err = os.WriteFile(c.Best, []byte(result.String()+"\n"), 0644)
+		if err != nil {
+			log.Fatalf("unable to write best configuration: %s\n", err.Error())
+		}
+	}
+}
+
+// load parses a log and returns it with the channel of the chip orientation selected as reference
+func load(infile string) (*recording.Recording, string, error) {
+	p := parser.NewXSensLogParser(infile)
+	if c.Frame != "" {
+		frame, err := measurement.ParseFrame(c.Frame)
+		if err != nil {
+			return nil, "", err
+		}
+		p.Frame = frame
+	}
+
+	err := p.Parse()
+	if err != nil {
+		return nil, "", err
+	}
+
+	r, err := p.GetRecording()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if r.Frame == measurement.FrameUnknown {
+		fmt.Fprintf(os.Stderr, "Warning: coordinate frame of %s is unknown, it is taken as NWU (set it with -frame)\n", infile)
+		r.AssumeFrame(measurement.FrameNWU)
+	}
+
+	var store *calibration.ProfileStore
+	if c.Profiles != "" {
+		store = calibration.NewProfileStore(c.Profiles)
+	}
+	_, err = processing.ApplyProfile(r, store)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The filter runs in body frame if the profile has an alignment, so is the chip orientation compared with
+	switch c.Reference {
+	case "euler":
+		if _, ok := r.Orientations[recording.AlignedChipOrientation]; ok {
+			return r, recording.AlignedChipOrientation, nil
+		}
+		return r, recording.ChipOrientation, nil
+	case "quat":
+		if _, ok := r.Orientations[recording.AlignedChipQuaternion]; ok {
+			return r, recording.AlignedChipQuaternion, nil
+		}
+		if _, ok := r.Orientations[recording.ChipQuaternion]; !ok {
+			return nil, "", fmt.Errorf("log has no Quat_q* columns")
+		}
+		return r, recording.ChipQuaternion, nil
+	default:
+		return nil, "", fmt.Errorf("unknown reference: %s", c.Reference)
+	}
+}
+
+// writeTable writes the parameters and errors in degrees of every candidate, best first
+func writeTable(space []tuning.Dimension, candidates []tuning.Candidate) error {
+	var b strings.Builder
+
+	names := make([]string, 0, len(space))
+	for _, d := range space {
+		names = append(names, d.Name)
+	}
+	sort.Strings(names)
+
+	b.WriteString(strings.Join(names, "\t") + "\tMean")
+	for _, infile := range c.Infiles {
+		b.WriteString("\t" + infile)
+	}
+	b.WriteString("\n")
+
+	for _, candidate := range candidates {
+		if candidate.Err != nil {
+			continue
+		}
+
+		for _, name := range names {
+			fmt.Fprintf(&b, "%.6g\t", candidate.Params[name])
+		}
+		fmt.Fprintf(&b, "%.4f", candidate.Score*180.0/math.Pi)
+		for _, e := range candidate.Errors {
+			fmt.Fprintf(&b, "\t%.4f", e*180.0/math.Pi)
+		}
+		b.WriteString("\n")
+	}
+
+	return os.WriteFile(c.Table, []byte(b.String()), 0644)
+}
@@ -1,17 +1,45 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/ptrngy/xsens_rotate/pkg/calibration"
+	"github.com/ptrngy/xsens_rotate/pkg/evaluation"
+	"github.com/ptrngy/xsens_rotate/pkg/imu"
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
 	"github.com/ptrngy/xsens_rotate/pkg/parser"
+	"github.com/ptrngy/xsens_rotate/pkg/processing"
+	"github.com/ptrngy/xsens_rotate/pkg/recording"
 	"github.com/ptrngy/xsens_rotate/pkg/visualizer"
+	"github.com/ptrngy/xsens_rotate/pkg/wmm"
 )
 
 type config struct {
 	Infile     string
+	Frame      string
+	Filter     string
+	Params     string
+	MagCal     bool
+	GyroCal    bool
+	AccCal     string
+	Profiles   string
+	SaveProf   bool
+	Declin     float64
+	Location   string
+	Date       string
+	WMM        string
+	MagDist    bool
+	DistFile   string
+	Lenient    bool
 	Parser     parser.XSensLogParser
+	Recording  *recording.Recording
 	Visualizer visualizer.XSensVisualizer
 }
 
@@ -19,6 +47,21 @@ var c config
 
 func main() {
 	flag.StringVar(&c.Infile, "input", "", "XSens log file to process. Extensions supported: .txt")
+	flag.StringVar(&c.Frame, "frame", "", "Coordinate frame of the log (ENU, NED, NWU), overrides the one named in the header")
+	flag.StringVar(&c.Filter, "filter", processing.DefaultFilter, "Software orientation filter, one of: "+strings.Join(imu.FilterNames(), ", "))
+	flag.StringVar(&c.Params, "params", "", "Software orientation filter parameters, e.g. beta=0.5")
+	flag.BoolVar(&c.MagCal, "magcal", false, "Fit hard-iron and soft-iron calibration to the magnetometer readings of the log and apply it before fusion")
+	flag.BoolVar(&c.GyroCal, "gyrocal", true, "Estimate the gyroscope bias from the stationary intervals of the log and subtract it before fusion, unless the calibration profile has one")
+	flag.StringVar(&c.AccCal, "acccal", "", "Accelerometer calibration file written by calibrate")
+	flag.StringVar(&c.Profiles, "profiles", "", "Directory of calibration profiles, the profile matching the DeviceId of the log is applied")
+	flag.BoolVar(&c.SaveProf, "saveprofile", false, "Store the calibrations in use into the profile of the device")
+	flag.Float64Var(&c.Declin, "declination", 0.0, "Magnetic declination in degrees, east positive, added to the heading to refer it to true north")
+	flag.StringVar(&c.Location, "location", "", "Recording location as latitude,longitude[,height in m], enables the World Magnetic Model reference")
+	flag.StringVar(&c.Date, "date", "", "Recording date as YYYY-MM-DD for the World Magnetic Model, today if not set")
+	flag.StringVar(&c.WMM, "wmm", "", "World Magnetic Model coefficient file (WMM.COF), the embedded release valid at -date is used if not set")
+	flag.BoolVar(&c.MagDist, "magdisturb", true, "Detect magnetic disturbances and leave the disturbed magnetometer readings out of the fusion")
+	flag.StringVar(&c.DistFile, "disturbances", "", "File to write the magnetically disturbed intervals to as a tab-separated table")
+	flag.BoolVar(&c.Lenient, "lenient", false, "Skip malformed rows of the log instead of failing")
 	flag.Parse()
 
 	if c.Infile == "" {
@@ -26,18 +69,245 @@ func main() {
 	}
 
 	c.Parser = *parser.NewXSensLogParser(c.Infile)
+	c.Parser.Lenient = c.Lenient
 
-	err := c.Parser.Parse()
+	if c.Frame != "" {
+		frame, err := measurement.ParseFrame(c.Frame)
+		if err != nil {
+			log.Fatalf("invalid frame: %s\n", err.Error())
+		}
+		c.Parser.Frame = frame
+	}
+
+	params, err := imu.ParseFilterParams(c.Params)
+	if err != nil {
+		log.Fatalf("invalid filter parameters: %s\n", err.Error())
+	}
+	declination := c.Declin * math.Pi / 180.0
+
+	calibrator := processing.NewCalibrationStage()
+	if c.AccCal != "" {
+		calibrator.Accelero, err = calibration.LoadAcceleroCalibration(c.AccCal)
+		if err != nil {
+			log.Fatalf("unable to load accelerometer calibration: %s\n", err.Error())
+		}
+	}
+
+	var reference *measurement.Vector3D
+	if c.Location != "" {
+		field, err := getExpectedField()
+		if err != nil {
+			log.Fatalf("unable to evaluate magnetic model: %s\n", err.Error())
+		}
+		fmt.Print(field)
+
+		r := field.GetMagneticReference()
+		reference = &r
+
+		// An explicit declination overrides the model
+		declinationSet := false
+		flag.Visit(func(f *flag.Flag) {
+			declinationSet = declinationSet || f.Name == "declination"
+		})
+		if !declinationSet {
+			declination = field.Declination
+		}
+	}
+
+	if c.Profiles == "" && c.SaveProf {
+		log.Fatalf("no profile directory defined")
+	}
+
+	err = c.Parser.Parse()
 	if err != nil {
 		log.Fatalf("unable to parse file: %s\n", err.Error())
 	}
 
-	fmt.Println("Processed ", len(c.Parser.Magneto), " measurements")
+	c.Recording, err = c.Parser.GetRecording()
+	if err != nil {
+		log.Fatalf("unable to parse file: %s\n", err.Error())
+	}
+
+	fmt.Print(c.Parser.Metadata)
+	if c.Parser.Dropped.Rows > 0 {
+		fmt.Print(c.Parser.Dropped)
+	}
 
-	c.Parser.CalculateIMUAngles()
-	c.Parser.CalculateRotMagnetoWithPrewarm()
+	// Corrections set on the command line take precedence over the profile
+	var store *calibration.ProfileStore
+	if c.Profiles != "" {
+		store = calibration.NewProfileStore(c.Profiles)
+	}
+	profile, err := processing.LoadProfile(store, c.Recording.DeviceID)
+	if err != nil {
+		log.Fatalf("unable to load calibration profile: %s\n", err.Error())
+	}
+	if profile != nil {
+		fmt.Print(profile)
+		calibrator.ApplyProfile(profile)
+	}
+
+	if c.Recording.Frame == measurement.FrameUnknown {
+		fmt.Println("Warning: coordinate frame of the log is unknown, it is taken as NWU (set it with -frame)")
+		c.Recording.AssumeFrame(measurement.FrameNWU)
+	}
+	if len(c.Parser.QuatOri) > 0 || len(c.Parser.MatOri) > 0 {
+		fmt.Printf("Largest deviation between chip orientation outputs: %.4f degree\n", processing.GetChipOrientationDeviation(c.Recording)*180.0/math.Pi)
+	}
+
+	fmt.Println("Processed ", c.Recording.Len(), " measurements, ", c.Recording.CountReadings(recording.Magneto), " with magnetometer reading")
+
+	if c.GyroCal && calibrator.Gyro == nil {
+		accelero := c.Recording.Vectors[recording.Accelero]
+		if calibrator.Accelero != nil {
+			accelero = calibrator.Accelero.ApplyAll(accelero)
+		}
+
+		calibrator.Gyro, err = calibration.EstimateGyroBias(accelero, c.Recording.Vectors[recording.Gyro], c.Recording.SamplingFrequency(), calibration.DefaultStillnessConfig())
+		if err != nil {
+			fmt.Printf("Gyroscope bias is not corrected: %s\n", err.Error())
+		} else {
+			fmt.Print(calibrator.Gyro)
+		}
+	}
+
+	if c.MagCal {
+		calibrator.Magneto, err = calibration.FitMagneto(c.Recording.Vectors[recording.Magneto])
+		if err != nil {
+			log.Fatalf("unable to calibrate magnetometer: %s\n", err.Error())
+		}
+		fmt.Print(calibrator.Magneto)
+	}
+
+	err = recording.Process(c.Recording, calibrator)
+	if err != nil {
+		log.Fatalf("unable to calibrate readings: %s\n", err.Error())
+	}
+
+	if c.MagDist {
+		detector := processing.NewDisturbanceStage(reference)
+		err = recording.Process(c.Recording, detector)
+		if err != nil {
+			log.Fatalf("unable to detect magnetic disturbance: %s\n", err.Error())
+		}
+
+		if detector.Detector == nil {
+			fmt.Println("Warning: no magnetometer readings, magnetic disturbance detection skipped")
+		} else {
+			intervals := processing.GetDisturbedIntervals(c.Recording)
+			fmt.Printf("Magnetic disturbance: %d intervals, expected field strength %.4f, inclination %.2f degree\n",
+				len(intervals), detector.Detector.FieldStrength, detector.Detector.Inclination*180.0/math.Pi)
+		}
+	}
 
-	c.Visualizer = *visualizer.NewXSensVisualizer(c.Parser)
+	if c.DistFile != "" {
+		err = processing.ExportDisturbances(c.Recording, c.DistFile)
+		if err != nil {
+			log.Fatalf("unable to export magnetic disturbances: %s\n", err.Error())
+		}
+	}
+
+	if c.SaveProf {
+		if profile == nil {
+			profile = calibration.NewProfile(c.Recording.DeviceID)
+		}
+		profile.Magneto = calibrator.Magneto
+		profile.Accelero = calibrator.Accelero
+		profile.Gyro = calibrator.Gyro
+		profile.Alignment = calibrator.Alignment
+
+		err = store.Save(*profile)
+		if err != nil {
+			log.Fatalf("unable to save calibration profile: %s\n", err.Error())
+		}
+		fmt.Println("Calibration profile saved for device ", profile.DeviceID)
+	}
+
+	filter := processing.NewFilterStage(c.Filter, params)
+	filter.MagneticReference = reference
+	prewarm := processing.NewPrewarmFilterStage(c.Filter, params)
+	prewarm.MagneticReference = reference
+
+	err = recording.Process(c.Recording, filter, prewarm)
+	if err != nil {
+		log.Fatalf("unable to run software filter: %s\n", err.Error())
+	}
+
+	// The accuracy is only reported, a log too short to evaluate is still plotted
+	report, err := processing.EvaluateAgainstChip(c.Recording, recording.IMUOrientation, evaluation.DefaultConfig())
+	if err != nil {
+		fmt.Printf("Software filter accuracy is not evaluated: %s\n", err.Error())
+	} else {
+		fmt.Print(report)
+	}
+
+	if reference != nil {
+		printReferenceDeviation("Chip rotated magnetometer", recording.RotatedMagneto, *reference)
+		printReferenceDeviation("Filter rotated magnetometer", recording.IMURotatedMagneto, *reference)
+		printReferenceDeviation("Prewarmed filter rotated magnetometer", recording.WarmRotatedMagneto, *reference)
+	}
+
+	err = recording.Process(c.Recording, processing.NewHeadingStage(declination))
+	if err != nil {
+		log.Fatalf("unable to calculate heading: %s\n", err.Error())
+	}
+
+	c.Visualizer = *visualizer.NewXSensVisualizer(c.Recording)
 	c.Visualizer.PlotBasics()
 	c.Visualizer.PlotIMURotated()
+	c.Visualizer.PlotIMUUncertainty()
+	c.Visualizer.PlotHeading()
+	c.Visualizer.PlotDisturbance()
+}
+
+// getExpectedField evaluates the World Magnetic Model at the location and date of the recording
+func getExpectedField() (wmm.Field, error) {
+	parts := strings.Split(c.Location, ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return wmm.Field{}, fmt.Errorf("invalid location: %q", c.Location)
+	}
+
+	coordinates := []float64{0.0, 0.0, 0.0}
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return wmm.Field{}, fmt.Errorf("invalid location: %q", c.Location)
+		}
+		coordinates[i] = value
+	}
+
+	date := time.Now()
+	if c.Date != "" {
+		var err error
+		date, err = time.Parse("2006-01-02", c.Date)
+		if err != nil {
+			return wmm.Field{}, fmt.Errorf("invalid date: %s", err.Error())
+		}
+	}
+
+	model, err := wmm.NewModelFor(date)
+	if c.WMM != "" {
+		model, err = wmm.LoadModel(c.WMM)
+	}
+	if err != nil {
+		return wmm.Field{}, err
+	}
+
+	field, err := model.Evaluate(coordinates[0], coordinates[1], coordinates[2]/1000.0, date)
+	if errors.Is(err, wmm.ErrOutOfValidity) {
+		fmt.Printf("Warning: %s %s, the field is extrapolated\n", model.Name, err.Error())
+		err = nil
+	}
+
+	return field, err
+}
+
+func printReferenceDeviation(name, channel string, reference measurement.Vector3D) {
+	deviation, err := processing.GetReferenceDeviation(c.Recording.Vectors[channel], reference)
+	if err != nil {
+		fmt.Printf("%s: %s\n", name, err.Error())
+		return
+	}
+
+	fmt.Printf("%s: %s\n", name, deviation)
 }
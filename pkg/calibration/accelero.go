@@ -0,0 +1,205 @@
+package calibration
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+)
+
+// StandardGravity is the nominal magnitude of the gravity vector in m / sec^2
+const StandardGravity = 9.80665
+
+// poseTolerance is the largest relative deviation of a static pose magnitude from gravity
+const poseTolerance = 0.2
+
+// ErrMissingPose is returned when the static poses do not cover all six axis directions
+var ErrMissingPose = errors.New("static poses do not cover all six axis directions")
+
+// AcceleroCalibration corrects bias, scale factor and cross-axis misalignment: corrected = Transform * (raw - Bias)
+type AcceleroCalibration struct {
+	Bias        measurement.Vector3D `json:"bias"`
+	Transform   [3][3]float64        `json:"transform"`
+	Gravity     float64              `json:"gravity"`
+	ResidualRMS float64              `json:"residual_rms"`
+	ResidualMax float64              `json:"residual_max"`
+	Poses       int                  `json:"poses"`
+}
+
+// FindPoses returns the mean accelerometer reading of each stationary interval of a log
+func FindPoses(accelero, gyro []measurement.Vector3D, samplingfreq float64, cfg StillnessConfig) []measurement.Vector3D {
+	result := make([]measurement.Vector3D, 0)
+
+	for _, interval := range DetectStill(accelero, gyro, samplingfreq, cfg) {
+		mean := measurement.Vector3D{X: 0.0, Y: 0.0, Z: 0.0, Frame: measurement.FrameSensor}
+		for i := interval.Start; i < interval.End; i++ {
+			mean.X += accelero[i].X
+			mean.Y += accelero[i].Y
+			mean.Z += accelero[i].Z
+		}
+		mean.Scale(1.0 / float64(interval.End-interval.Start))
+
+		result = append(result, mean)
+	}
+
+	return result
+}
+
+// getPoseReference returns the ideal reading of a static pose: gravity along the dominant axis of the reading.
+// The second return value is the index of the direction: +X, -X, +Y, -Y, +Z, -Z.
+func getPoseReference(pose measurement.Vector3D, gravity float64) (measurement.Vector3D, int) {
+	axis := 0
+	for k := 1; k < 3; k++ {
+		if math.Abs(getComponent(pose, k)) > math.Abs(getComponent(pose, axis)) {
+			axis = k
+		}
+	}
+
+	sign := 1.0
+	direction := 2 * axis
+	if getComponent(pose, axis) < 0.0 {
+		sign = -1.0
+		direction++
+	}
+
+	reference := [3]float64{0.0, 0.0, 0.0}
+	reference[axis] = sign * gravity
+
+	return measurement.Vector3D{X: reference[0], Y: reference[1], Z: reference[2], Frame: pose.Frame}, direction
+}
+
+// FitAccelero solves for bias, scale and misalignment from static poses, the mean readings of a sensor resting
+// with each of its axes pointing up and down. Readings are modelled as raw = K * reference + bias, where the
+// reference is gravity along the dominant axis. Poses whose magnitude is far from gravity are skipped.
+func FitAccelero(poses []measurement.Vector3D) (*AcceleroCalibration, error) {
+	references := make([]measurement.Vector3D, 0, len(poses))
+	measured := make([]measurement.Vector3D, 0, len(poses))
+	covered := [6]bool{}
+
+	for _, pose := range poses {
+		if math.Abs(pose.Norm()-StandardGravity) > poseTolerance*StandardGravity {
+			continue
+		}
+
+		reference, direction := getPoseReference(pose, StandardGravity)
+		references = append(references, reference)
+		measured = append(measured, pose)
+		covered[direction] = true
+	}
+
+	for _, c := range covered {
+		if !c {
+			return nil, ErrMissingPose
+		}
+	}
+
+	d := make([][]float64, 0, len(references))
+	for _, r := range references {
+		d = append(d, []float64{r.X, r.Y, r.Z, 1.0})
+	}
+
+	// Each output axis is an independent linear least squares problem
+	var k [3][3]float64
+	bias := [3]float64{}
+	for i := 0; i < 3; i++ {
+		y := make([]float64, 0, len(measured))
+		for _, m := range measured {
+			y = append(y, getComponent(m, i))
+		}
+
+		x, err := solveLeastSquares(d, y)
+		if err != nil {
+			return nil, err
+		}
+
+		k[i] = [3]float64{x[0], x[1], x[2]}
+		bias[i] = x[3]
+	}
+
+	inverse, err := getInverse(k)
+	if err != nil {
+		return nil, err
+	}
+
+	c := AcceleroCalibration{
+		Bias:      measurement.Vector3D{X: bias[0], Y: bias[1], Z: bias[2], Frame: measurement.FrameSensor},
+		Transform: inverse,
+		Gravity:   StandardGravity,
+		Poses:     len(measured),
+	}
+
+	for i, m := range measured {
+		corrected := c.Apply(m)
+		residual := measurement.Vector3D{
+			X: corrected.X - references[i].X,
+			Y: corrected.Y - references[i].Y,
+			Z: corrected.Z - references[i].Z,
+		}.Norm()
+
+		c.ResidualRMS += residual * residual / float64(c.Poses)
+		c.ResidualMax = math.Max(c.ResidualMax, residual)
+	}
+	c.ResidualRMS = math.Sqrt(c.ResidualRMS)
+
+	return &c, nil
+}
+
+// LoadAcceleroCalibration reads a calibration file written by Save
+func LoadAcceleroCalibration(path string) (*AcceleroCalibration, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := AcceleroCalibration{}
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return nil, fmt.Errorf("invalid accelerometer calibration file %s: %s", path, err.Error())
+	}
+
+	return &c, nil
+}
+
+// Save writes the calibration to a JSON file
+func (c AcceleroCalibration) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+// Apply returns the corrected accelerometer reading
+func (c AcceleroCalibration) Apply(a measurement.Vector3D) measurement.Vector3D {
+	return transform(c.Transform, c.Bias, a)
+}
+
+// ApplyAll returns the corrected accelerometer readings
+func (c AcceleroCalibration) ApplyAll(accelero []measurement.Vector3D) []measurement.Vector3D {
+	result := make([]measurement.Vector3D, 0, len(accelero))
+
+	for _, a := range accelero {
+		result = append(result, c.Apply(a))
+	}
+
+	return result
+}
+
+func (c AcceleroCalibration) String() string {
+	return fmt.Sprintf("Accelerometer calibration (%d static poses)\n"+
+		"Bias:      %.6f %.6f %.6f\n"+
+		"Transform: %.6f %.6f %.6f\n"+
+		"           %.6f %.6f %.6f\n"+
+		"           %.6f %.6f %.6f\n"+
+		"Residual RMS: %.6f, max: %.6f\n",
+		c.Poses,
+		c.Bias.X, c.Bias.Y, c.Bias.Z,
+		c.Transform[0][0], c.Transform[0][1], c.Transform[0][2],
+		c.Transform[1][0], c.Transform[1][1], c.Transform[1][2],
+		c.Transform[2][0], c.Transform[2][1], c.Transform[2][2],
+		c.ResidualRMS, c.ResidualMax)
+}
@@ -0,0 +1,68 @@
+package calibration
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+)
+
+// getPoses returns the ideal readings of the six axis directions
+func getPoses() []measurement.Vector3D {
+	g := StandardGravity
+
+	return []measurement.Vector3D{
+		{X: g}, {X: -g},
+		{Y: g}, {Y: -g},
+		{Z: g}, {Z: -g},
+	}
+}
+
+func TestFitAccelero(t *testing.T) {
+	// Scale factor errors on the diagonal, cross-axis misalignment off it
+	k := [3][3]float64{{1.02, 0.01, -0.005}, {0.003, 0.98, 0.008}, {-0.01, 0.004, 1.01}}
+	bias := measurement.Vector3D{X: 0.12, Y: -0.08, Z: 0.2}
+
+	// The redundant poses and the one far from gravity (a bump while resting) must not change the fit
+	poses := append(getPoses(), getPoses()[0], getPoses()[4], measurement.Vector3D{X: 3.0 * StandardGravity})
+	measured := distort(poses, k, bias)
+
+	c, err := FitAccelero(measured)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if c.Poses != 8 {
+		t.Errorf("poses: got %d, want 8", c.Poses)
+	}
+
+	if math.Abs(c.Bias.X-bias.X) > testTolerance || math.Abs(c.Bias.Y-bias.Y) > testTolerance ||
+		math.Abs(c.Bias.Z-bias.Z) > testTolerance {
+		t.Errorf("bias: got %+v, want %+v", c.Bias, bias)
+	}
+
+	product := multiply(c.Transform, k)
+	assertScaledIdentity(t, "transform * distortion", product)
+	if math.Abs(product[0][0]-1.0) > testTolerance {
+		t.Errorf("transform * distortion: scale %f, want 1", product[0][0])
+	}
+
+	for _, m := range measured[:6] {
+		corrected := c.Apply(m)
+		if math.Abs(corrected.Norm()-StandardGravity) > testTolerance {
+			t.Errorf("corrected pose %+v is not gravity", corrected)
+		}
+	}
+
+	if c.ResidualRMS > testTolerance {
+		t.Errorf("residual RMS: got %f, want 0", c.ResidualRMS)
+	}
+}
+
+func TestFitAcceleroMissingPose(t *testing.T) {
+	// The sensor never rested with its Z axis pointing down
+	_, err := FitAccelero(getPoses()[:5])
+	if err != ErrMissingPose {
+		t.Errorf("got error %v, want %v", err, ErrMissingPose)
+	}
+}
@@ -0,0 +1,251 @@
+package calibration
+
+import (
+	"errors"
+	"math"
+
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+)
+
+// planarCoverage is the ratio of the smallest and largest standard deviation of the samples below which they are
+// treated as lying in a plane, e.g. a sensor rotated around a single axis
+const planarCoverage = 0.25
+
+// ErrInsufficientCoverage is returned when the samples do not describe an ellipsoid
+var ErrInsufficientCoverage = errors.New("samples do not cover enough orientations for an ellipsoid fit")
+
+// ellipsoidFit maps the samples of an ellipsoid onto a sphere: |Transform * (v - Center)| = Radius
+type ellipsoidFit struct {
+	Center    measurement.Vector3D
+	Transform [3][3]float64
+	Radius    float64
+	Planar    bool
+}
+
+// fitEllipsoid fits an ellipsoid to the non empty samples with linear least squares. The transform scales the result
+// to the given radius, or to the geometric mean of the ellipsoid radii if radius is not positive. Samples lying in a
+// plane get a 2D fit, which leaves the component normal to that plane untouched.
+func fitEllipsoid(samples []measurement.Vector3D, radius float64) (*ellipsoidFit, error) {
+	points := make([]measurement.Vector3D, 0, len(samples))
+	for _, s := range samples {
+		if !s.IsEmpty() {
+			points = append(points, s)
+		}
+	}
+
+	if len(points) < 10 {
+		return nil, ErrInsufficientCoverage
+	}
+
+	// Center and scale the samples to keep the normal equations well conditioned
+	mean := measurement.Vector3D{X: 0.0, Y: 0.0, Z: 0.0}
+	for _, p := range points {
+		mean.X += p.X
+		mean.Y += p.Y
+		mean.Z += p.Z
+	}
+	mean.Scale(1.0 / float64(len(points)))
+
+	var covariance [3][3]float64
+	for _, p := range points {
+		d := [3]float64{p.X - mean.X, p.Y - mean.Y, p.Z - mean.Z}
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				covariance[i][j] += d[i] * d[j] / float64(len(points))
+			}
+		}
+	}
+
+	spread, axes := getSymmetricEigen(covariance)
+	if spread[0] <= 0.0 {
+		return nil, ErrInsufficientCoverage
+	}
+	scale := math.Sqrt((spread[0] + spread[1] + spread[2]) / 3.0)
+
+	if math.Sqrt(math.Max(spread[2], 0.0)/spread[0]) < planarCoverage {
+		if math.Sqrt(math.Max(spread[1], 0.0)/spread[0]) < planarCoverage {
+			return nil, ErrInsufficientCoverage
+		}
+
+		return fitPlanarEllipse(points, mean, scale, axes, radius)
+	}
+
+	d := make([][]float64, 0, len(points))
+	y := make([]float64, 0, len(points))
+	for _, p := range points {
+		u := [3]float64{(p.X - mean.X) / scale, (p.Y - mean.Y) / scale, (p.Z - mean.Z) / scale}
+		d = append(d, []float64{u[0] * u[0], u[1] * u[1], u[2] * u[2], 2 * u[1] * u[2], 2 * u[0] * u[2], 2 * u[0] * u[1], 2 * u[0], 2 * u[1], 2 * u[2]})
+		y = append(y, 1.0)
+	}
+
+	params, err := solveLeastSquares(d, y)
+	if err != nil {
+		return nil, ErrInsufficientCoverage
+	}
+
+	// Quadric u^T M u + 2 n^T u = 1 rewritten as (u - c)^T M (u - c) = k
+	m := [3][3]float64{
+		{params[0], params[5], params[4]},
+		{params[5], params[1], params[3]},
+		{params[4], params[3], params[2]},
+	}
+	minv, err := getInverse(m)
+	if err != nil {
+		return nil, ErrInsufficientCoverage
+	}
+
+	c := [3]float64{}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			c[i] -= minv[i][j] * params[6+j]
+		}
+	}
+
+	k := 1.0
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			k += c[i] * m[i][j] * c[j]
+		}
+	}
+
+	// Ellipsoid matrix in sensor units, positive definite for a real ellipsoid
+	var e [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			e[i][j] = m[i][j] / k / (scale * scale)
+		}
+	}
+
+	values, _ := getSymmetricEigen(e)
+	if values[2] <= 0.0 {
+		return nil, ErrInsufficientCoverage
+	}
+
+	if radius <= 0.0 {
+		radius = math.Pow(values[0]*values[1]*values[2], -1.0/6.0)
+	}
+
+	result := ellipsoidFit{
+		Center: measurement.Vector3D{X: mean.X + scale*c[0], Y: mean.Y + scale*c[1], Z: mean.Z + scale*c[2], Frame: mean.Frame},
+		Radius: radius,
+	}
+
+	sqrtE := getSymmetricSqrt(e)
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			result.Transform[i][j] = radius * sqrtE[i][j]
+		}
+	}
+
+	return &result, nil
+}
+
+// fitPlanarEllipse fits an ellipse in the plane of the two main axes of the samples
+func fitPlanarEllipse(points []measurement.Vector3D, mean measurement.Vector3D, scale float64, axes [3][3]float64, radius float64) (*ellipsoidFit, error) {
+	e1 := measurement.Vector3D{X: axes[0][0], Y: axes[1][0], Z: axes[2][0]}
+	e2 := measurement.Vector3D{X: axes[0][1], Y: axes[1][1], Z: axes[2][1]}
+	n := measurement.Vector3D{X: axes[0][2], Y: axes[1][2], Z: axes[2][2]}
+
+	d := make([][]float64, 0, len(points))
+	y := make([]float64, 0, len(points))
+	for _, p := range points {
+		diff := measurement.Vector3D{X: p.X - mean.X, Y: p.Y - mean.Y, Z: p.Z - mean.Z}
+		a, b := e1.Dot(diff)/scale, e2.Dot(diff)/scale
+		d = append(d, []float64{a * a, b * b, 2 * a * b, 2 * a, 2 * b})
+		y = append(y, 1.0)
+	}
+
+	params, err := solveLeastSquares(d, y)
+	if err != nil {
+		return nil, ErrInsufficientCoverage
+	}
+
+	// Conic u^T M u + 2 n^T u = 1 rewritten as (u - c)^T M (u - c) = k
+	m := [3][3]float64{{params[0], params[2], 0.0}, {params[2], params[1], 0.0}, {0.0, 0.0, 1.0}}
+	det := m[0][0]*m[1][1] - m[0][1]*m[1][0]
+	if det <= 0.0 {
+		return nil, ErrInsufficientCoverage
+	}
+
+	c := [2]float64{
+		-(m[1][1]*params[3] - m[0][1]*params[4]) / det,
+		-(-m[1][0]*params[3] + m[0][0]*params[4]) / det,
+	}
+	k := 1.0 + c[0]*c[0]*m[0][0] + 2.0*c[0]*c[1]*m[0][1] + c[1]*c[1]*m[1][1]
+
+	e := [3][3]float64{{1.0, 0.0, 0.0}, {0.0, 1.0, 0.0}, {0.0, 0.0, 1.0}}
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 2; j++ {
+			e[i][j] = m[i][j] / k / (scale * scale)
+		}
+	}
+
+	values, _ := getSymmetricEigen([3][3]float64{{e[0][0], e[0][1], 0.0}, {e[1][0], e[1][1], 0.0}, {0.0, 0.0, 0.0}})
+	if values[1] <= 0.0 {
+		return nil, ErrInsufficientCoverage
+	}
+
+	if radius <= 0.0 {
+		radius = math.Pow(values[0]*values[1], -0.25)
+	}
+
+	// Scale the ellipse onto the circle, the normal axis is kept as is
+	t := getSymmetricSqrt(e)
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 2; j++ {
+			t[i][j] *= radius
+		}
+	}
+	t[2][2] = 1.0
+
+	// The offset along the normal can not be observed from planar samples, use the smallest one on the normal axis
+	center := measurement.Vector3D{
+		X:     mean.X + scale*(c[0]*e1.X+c[1]*e2.X),
+		Y:     mean.Y + scale*(c[0]*e1.Y+c[1]*e2.Y),
+		Z:     mean.Z + scale*(c[0]*e1.Z+c[1]*e2.Z),
+		Frame: mean.Frame,
+	}
+	offset := center.Dot(n)
+	center.X -= offset * n.X
+	center.Y -= offset * n.Y
+	center.Z -= offset * n.Z
+
+	// Transform = B * T * B^T with B the basis of the plane axes and the normal
+	basis := [3]measurement.Vector3D{e1, e2, n}
+	result := ellipsoidFit{Center: center, Radius: radius, Planar: true}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for a := 0; a < 3; a++ {
+				for b := 0; b < 3; b++ {
+					result.Transform[i][j] += getComponent(basis[a], i) * t[a][b] * getComponent(basis[b], j)
+				}
+			}
+		}
+	}
+
+	return &result, nil
+}
+
+// getComponent returns the X, Y or Z component of the vector by index
+func getComponent(v measurement.Vector3D, idx int) float64 {
+	switch idx {
+	case 0:
+		return v.X
+	case 1:
+		return v.Y
+	}
+
+	return v.Z
+}
+
+// transform returns M * (v - offset)
+func transform(m [3][3]float64, offset, v measurement.Vector3D) measurement.Vector3D {
+	d := [3]float64{v.X - offset.X, v.Y - offset.Y, v.Z - offset.Z}
+
+	return measurement.Vector3D{
+		X:     m[0][0]*d[0] + m[0][1]*d[1] + m[0][2]*d[2],
+		Y:     m[1][0]*d[0] + m[1][1]*d[1] + m[1][2]*d[2],
+		Z:     m[2][0]*d[0] + m[2][1]*d[1] + m[2][2]*d[2],
+		Frame: v.Frame,
+	}
+}
@@ -0,0 +1,156 @@
+package calibration
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+)
+
+// ErrNotStill is returned when no stationary interval is found in the log
+var ErrNotStill = errors.New("no stationary interval found")
+
+// StillnessConfig holds the thresholds of stationary interval detection
+type StillnessConfig struct {
+	Window      float64 // length of the sliding window in seconds
+	AcceleroStd float64 // largest standard deviation of the accelerometer within the window in m / sec^2
+	GyroRate    float64 // largest angular rate within the window in radians / sec
+	MinDuration float64 // shortest stationary interval in seconds
+}
+
+// DefaultStillnessConfig returns thresholds suiting the MTi sensors of the sample logs
+func DefaultStillnessConfig() StillnessConfig {
+	return StillnessConfig{
+		Window:      0.25,
+		AcceleroStd: 0.1,
+		GyroRate:    0.05,
+		MinDuration: 1.0,
+	}
+}
+
+// Interval is a range of sample indexes, Start inclusive and End exclusive
+type Interval struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// DetectStill returns the intervals where the accelerometer variance and the gyroscope magnitude stay below the
+// thresholds for at least the minimum duration
+func DetectStill(accelero, gyro []measurement.Vector3D, samplingfreq float64, cfg StillnessConfig) []Interval {
+	n := len(accelero)
+	if len(gyro) < n {
+		n = len(gyro)
+	}
+
+	window := int(math.Max(1.0, cfg.Window*samplingfreq))
+	minLength := int(math.Max(1.0, cfg.MinDuration*samplingfreq))
+
+	// Prefix sums give the variance of any window in constant time
+	sum := make([][3]float64, n+1)
+	sumSq := make([][3]float64, n+1)
+	for i := 0; i < n; i++ {
+		a := [3]float64{accelero[i].X, accelero[i].Y, accelero[i].Z}
+		for k := 0; k < 3; k++ {
+			sum[i+1][k] = sum[i][k] + a[k]
+			sumSq[i+1][k] = sumSq[i][k] + a[k]*a[k]
+		}
+	}
+
+	result := make([]Interval, 0)
+	start := -1
+	for i := 0; i <= n; i++ {
+		still := false
+		if i < n {
+			from := int(math.Max(0.0, float64(i-window/2)))
+			to := int(math.Min(float64(n), float64(from+window)))
+			count := float64(to - from)
+
+			variance := 0.0
+			for k := 0; k < 3; k++ {
+				mean := (sum[to][k] - sum[from][k]) / count
+				variance += (sumSq[to][k]-sumSq[from][k])/count - mean*mean
+			}
+
+			still = math.Sqrt(math.Max(variance, 0.0)) < cfg.AcceleroStd && gyro[i].Norm() < cfg.GyroRate
+		}
+
+		if still && start == -1 {
+			start = i
+		}
+
+		if !still && start != -1 {
+			if i-start >= minLength {
+				result = append(result, Interval{Start: start, End: i})
+			}
+			start = -1
+		}
+	}
+
+	return result
+}
+
+// GyroCalibration holds the gyroscope bias and noise estimated from the stationary intervals of a log
+type GyroCalibration struct {
+	Bias      measurement.Vector3D `json:"bias"`
+	Noise     measurement.Vector3D `json:"noise"`
+	Intervals []Interval           `json:"intervals,omitempty"`
+	Samples   int                  `json:"samples"`
+}
+
+// EstimateGyroBias estimates the per-axis gyroscope bias and noise standard deviation from the stationary intervals
+func EstimateGyroBias(accelero, gyro []measurement.Vector3D, samplingfreq float64, cfg StillnessConfig) (*GyroCalibration, error) {
+	c := GyroCalibration{
+		Bias:      measurement.Vector3D{X: 0.0, Y: 0.0, Z: 0.0, Frame: measurement.FrameSensor},
+		Noise:     measurement.Vector3D{X: 0.0, Y: 0.0, Z: 0.0, Frame: measurement.FrameSensor},
+		Intervals: DetectStill(accelero, gyro, samplingfreq, cfg),
+	}
+
+	if len(c.Intervals) == 0 {
+		return nil, ErrNotStill
+	}
+
+	for _, interval := range c.Intervals {
+		for i := interval.Start; i < interval.End; i++ {
+			c.Bias.X += gyro[i].X
+			c.Bias.Y += gyro[i].Y
+			c.Bias.Z += gyro[i].Z
+			c.Samples++
+		}
+	}
+	c.Bias.Scale(1.0 / float64(c.Samples))
+
+	for _, interval := range c.Intervals {
+		for i := interval.Start; i < interval.End; i++ {
+			c.Noise.X += math.Pow(gyro[i].X-c.Bias.X, 2)
+			c.Noise.Y += math.Pow(gyro[i].Y-c.Bias.Y, 2)
+			c.Noise.Z += math.Pow(gyro[i].Z-c.Bias.Z, 2)
+		}
+	}
+	c.Noise = measurement.Vector3D{
+		X:     math.Sqrt(c.Noise.X / float64(c.Samples)),
+		Y:     math.Sqrt(c.Noise.Y / float64(c.Samples)),
+		Z:     math.Sqrt(c.Noise.Z / float64(c.Samples)),
+		Frame: measurement.FrameSensor,
+	}
+
+	return &c, nil
+}
+
+// Apply returns the bias corrected gyroscope reading
+func (c GyroCalibration) Apply(g measurement.Vector3D) measurement.Vector3D {
+	g.X -= c.Bias.X
+	g.Y -= c.Bias.Y
+	g.Z -= c.Bias.Z
+
+	return g
+}
+
+func (c GyroCalibration) String() string {
+	return fmt.Sprintf("Gyroscope calibration (%d samples in %d stationary intervals)\n"+
+		"Bias:  %.6f %.6f %.6f rad/s\n"+
+		"Noise: %.6f %.6f %.6f rad/s\n",
+		c.Samples, len(c.Intervals),
+		c.Bias.X, c.Bias.Y, c.Bias.Z,
+		c.Noise.X, c.Noise.Y, c.Noise.Z)
+}
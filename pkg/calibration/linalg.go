@@ -0,0 +1,172 @@
+package calibration
+
+import (
+	"errors"
+	"math"
+)
+
+// errSingular is returned when a linear system has no unique solution
+var errSingular = errors.New("singular linear system")
+
+// solveLinear solves a * x = b with Gaussian elimination and partial pivoting, a and b are modified
+func solveLinear(a [][]float64, b []float64) ([]float64, error) {
+	n := len(b)
+
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return nil, errSingular
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		b[col], b[pivot] = b[pivot], b[col]
+
+		for row := col + 1; row < n; row++ {
+			factor := a[row][col] / a[col][col]
+			for k := col; k < n; k++ {
+				a[row][k] -= factor * a[col][k]
+			}
+			b[row] -= factor * b[col]
+		}
+	}
+
+	x := make([]float64, n)
+	for row := n - 1; row >= 0; row-- {
+		sum := b[row]
+		for k := row + 1; k < n; k++ {
+			sum -= a[row][k] * x[k]
+		}
+		x[row] = sum / a[row][row]
+	}
+
+	return x, nil
+}
+
+// solveLeastSquares returns x minimising |D * x - y| through the normal equations
+func solveLeastSquares(d [][]float64, y []float64) ([]float64, error) {
+	if len(d) == 0 {
+		return nil, errSingular
+	}
+
+	n := len(d[0])
+	a := make([][]float64, n)
+	b := make([]float64, n)
+	for i := range a {
+		a[i] = make([]float64, n)
+	}
+
+	for r, row := range d {
+		for i := 0; i < n; i++ {
+			b[i] += row[i] * y[r]
+			for j := 0; j < n; j++ {
+				a[i][j] += row[i] * row[j]
+			}
+		}
+	}
+
+	return solveLinear(a, b)
+}
+
+// getSymmetricEigen returns the eigenvalues and the eigenvectors (as columns) of a symmetric 3x3 matrix, using
+// Jacobi rotations. Eigenvalues are sorted in descending order.
+func getSymmetricEigen(m [3][3]float64) ([3]float64, [3][3]float64) {
+	a := m
+	v := [3][3]float64{{1.0, 0.0, 0.0}, {0.0, 1.0, 0.0}, {0.0, 0.0, 1.0}}
+
+	for sweep := 0; sweep < 50; sweep++ {
+		off := a[0][1]*a[0][1] + a[0][2]*a[0][2] + a[1][2]*a[1][2]
+		if off < 1e-30 {
+			break
+		}
+
+		for p := 0; p < 2; p++ {
+			for q := p + 1; q < 3; q++ {
+				if a[p][q] == 0.0 {
+					continue
+				}
+
+				// Rotation zeroing a[p][q]
+				theta := (a[q][q] - a[p][p]) / (2.0 * a[p][q])
+				t := math.Copysign(1.0, theta) / (math.Abs(theta) + math.Sqrt(theta*theta+1.0))
+				c := 1.0 / math.Sqrt(t*t+1.0)
+				s := t * c
+
+				for k := 0; k < 3; k++ {
+					akp, akq := a[k][p], a[k][q]
+					a[k][p] = c*akp - s*akq
+					a[k][q] = s*akp + c*akq
+				}
+				for k := 0; k < 3; k++ {
+					apk, aqk := a[p][k], a[q][k]
+					a[p][k] = c*apk - s*aqk
+					a[q][k] = s*apk + c*aqk
+				}
+				for k := 0; k < 3; k++ {
+					vkp, vkq := v[k][p], v[k][q]
+					v[k][p] = c*vkp - s*vkq
+					v[k][q] = s*vkp + c*vkq
+				}
+			}
+		}
+	}
+
+	values := [3]float64{a[0][0], a[1][1], a[2][2]}
+
+	// Sort eigenpairs in descending order
+	for i := 0; i < 3; i++ {
+		for j := i + 1; j < 3; j++ {
+			if values[j] > values[i] {
+				values[i], values[j] = values[j], values[i]
+				for k := 0; k < 3; k++ {
+					v[k][i], v[k][j] = v[k][j], v[k][i]
+				}
+			}
+		}
+	}
+
+	return values, v
+}
+
+// getSymmetricSqrt returns the symmetric square root of a positive definite 3x3 matrix
+func getSymmetricSqrt(m [3][3]float64) [3][3]float64 {
+	values, vectors := getSymmetricEigen(m)
+
+	var result [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				result[i][j] += vectors[i][k] * math.Sqrt(values[k]) * vectors[j][k]
+			}
+		}
+	}
+
+	return result
+}
+
+// getInverse returns the inverse of a 3x3 matrix
+func getInverse(m [3][3]float64) ([3][3]float64, error) {
+	var result [3][3]float64
+
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	if math.Abs(det) < 1e-15 {
+		return result, errSingular
+	}
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			// Cofactor of the transposed position, rows and columns taken cyclically
+			a, b := (j+1)%3, (j+2)%3
+			c, d := (i+1)%3, (i+2)%3
+			result[i][j] = (m[a][c]*m[b][d] - m[a][d]*m[b][c]) / det
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,96 @@
+package calibration
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+)
+
+// MagnetoCalibration corrects hard-iron and soft-iron distortion: corrected = SoftIron * (raw - HardIron)
+type MagnetoCalibration struct {
+	HardIron      measurement.Vector3D `json:"hard_iron"`
+	SoftIron      [3][3]float64        `json:"soft_iron"`
+	FieldStrength float64              `json:"field_strength"`
+	ResidualRMS   float64              `json:"residual_rms"`
+	ResidualMax   float64              `json:"residual_max"`
+	Samples       int                  `json:"samples"`
+	Planar        bool                 `json:"planar"`
+}
+
+// FitMagneto fits an ellipsoid to the magnetometer readings of a log, empty readings are skipped.
+// Readings of a sensor rotated around a single axis only get an in-plane correction.
+func FitMagneto(magneto []measurement.Vector3D) (*MagnetoCalibration, error) {
+	fit, err := fitEllipsoid(magneto, 0.0)
+	if err != nil {
+		return nil, err
+	}
+
+	c := MagnetoCalibration{
+		HardIron: fit.Center,
+		SoftIron: fit.Transform,
+		Planar:   fit.Planar,
+	}
+
+	// Field strength and residuals are the magnitude of the corrected readings and their deviation
+	magnitudes := make([]float64, 0, len(magneto))
+	for _, m := range magneto {
+		if !m.IsEmpty() {
+			magnitudes = append(magnitudes, c.Apply(m).Norm())
+		}
+	}
+
+	c.Samples = len(magnitudes)
+	for _, m := range magnitudes {
+		c.FieldStrength += m / float64(c.Samples)
+	}
+
+	for _, m := range magnitudes {
+		residual := m - c.FieldStrength
+		c.ResidualRMS += residual * residual / float64(c.Samples)
+		c.ResidualMax = math.Max(c.ResidualMax, math.Abs(residual))
+	}
+	c.ResidualRMS = math.Sqrt(c.ResidualRMS)
+
+	return &c, nil
+}
+
+// Apply returns the corrected magnetometer reading, empty readings stay empty
+func (c MagnetoCalibration) Apply(m measurement.Vector3D) measurement.Vector3D {
+	if m.IsEmpty() {
+		return m
+	}
+
+	return transform(c.SoftIron, c.HardIron, m)
+}
+
+// ApplyAll returns the corrected magnetometer readings
+func (c MagnetoCalibration) ApplyAll(magneto []measurement.Vector3D) []measurement.Vector3D {
+	result := make([]measurement.Vector3D, 0, len(magneto))
+
+	for _, m := range magneto {
+		result = append(result, c.Apply(m))
+	}
+
+	return result
+}
+
+func (c MagnetoCalibration) String() string {
+	fit := "ellipsoid"
+	if c.Planar {
+		fit = "planar"
+	}
+
+	return fmt.Sprintf("Magnetometer calibration (%s fit of %d samples)\n"+
+		"Hard-iron: %.6f %.6f %.6f\n"+
+		"Soft-iron: %.6f %.6f %.6f\n"+
+		"           %.6f %.6f %.6f\n"+
+		"           %.6f %.6f %.6f\n"+
+		"Field strength: %.6f, residual RMS: %.6f, max: %.6f\n",
+		fit, c.Samples,
+		c.HardIron.X, c.HardIron.Y, c.HardIron.Z,
+		c.SoftIron[0][0], c.SoftIron[0][1], c.SoftIron[0][2],
+		c.SoftIron[1][0], c.SoftIron[1][1], c.SoftIron[1][2],
+		c.SoftIron[2][0], c.SoftIron[2][1], c.SoftIron[2][2],
+		c.FieldStrength, c.ResidualRMS, c.ResidualMax)
+}
@@ -0,0 +1,150 @@
+package calibration
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+)
+
+const testTolerance = 1e-6
+
+// getSphere returns evenly spread directions of the given magnitude (Fibonacci lattice)
+func getSphere(n int, magnitude float64) []measurement.Vector3D {
+	result := make([]measurement.Vector3D, 0, n)
+	golden := math.Pi * (3.0 - math.Sqrt(5.0))
+
+	for i := 0; i < n; i++ {
+		z := 1.0 - 2.0*(float64(i)+0.5)/float64(n)
+		r := math.Sqrt(1.0 - z*z)
+		phi := golden * float64(i)
+		result = append(result, measurement.Vector3D{X: magnitude * r * math.Cos(phi), Y: magnitude * r * math.Sin(phi), Z: magnitude * z})
+	}
+
+	return result
+}
+
+// distort returns M * v + offset for every vector
+func distort(vectors []measurement.Vector3D, m [3][3]float64, offset measurement.Vector3D) []measurement.Vector3D {
+	result := make([]measurement.Vector3D, 0, len(vectors))
+
+	for _, v := range vectors {
+		d := transform(m, measurement.Vector3D{}, v)
+		result = append(result, measurement.Vector3D{X: d.X + offset.X, Y: d.Y + offset.Y, Z: d.Z + offset.Z})
+	}
+
+	return result
+}
+
+// assertScaledIdentity fails the test if the matrix is not a multiple of the identity
+func assertScaledIdentity(t *testing.T, name string, m [3][3]float64) {
+	t.Helper()
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			want := 0.0
+			if i == j {
+				want = m[0][0]
+			}
+			if math.Abs(m[i][j]-want) > testTolerance {
+				t.Errorf("%s: [%d][%d] = %f, want %f", name, i, j, m[i][j], want)
+			}
+		}
+	}
+}
+
+// multiply returns the matrix product a * b
+func multiply(a, b [3][3]float64) [3][3]float64 {
+	var result [3][3]float64
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				result[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+
+	return result
+}
+
+func TestFitMagneto(t *testing.T) {
+	softIron := [3][3]float64{{1.1, 0.05, -0.02}, {0.05, 0.9, 0.03}, {-0.02, 0.03, 1.05}}
+	hardIron := measurement.Vector3D{X: 12.0, Y: -7.0, Z: 20.0}
+	magneto := distort(getSphere(200, 45.0), softIron, hardIron)
+
+	// Empty readings of the rows without magnetometer are skipped
+	magneto = append(magneto, measurement.Vector3D{})
+
+	c, err := FitMagneto(magneto)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if c.Planar {
+		t.Errorf("ellipsoid readings fitted as planar")
+	}
+
+	if c.Samples != 200 {
+		t.Errorf("samples: got %d, want 200", c.Samples)
+	}
+
+	if math.Abs(c.HardIron.X-hardIron.X) > testTolerance || math.Abs(c.HardIron.Y-hardIron.Y) > testTolerance ||
+		math.Abs(c.HardIron.Z-hardIron.Z) > testTolerance {
+		t.Errorf("hard-iron: got %+v, want %+v", c.HardIron, hardIron)
+	}
+
+	// The symmetric correction undoes the symmetric distortion up to the scale of the field
+	assertScaledIdentity(t, "soft-iron * distortion", multiply(c.SoftIron, softIron))
+
+	if c.ResidualRMS > testTolerance {
+		t.Errorf("residual RMS: got %f, want 0", c.ResidualRMS)
+	}
+}
+
+func TestFitMagnetoPlanar(t *testing.T) {
+	// A sensor rotated around its Z axis: the horizontal field turns, the vertical component is constant
+	horizontal := make([]measurement.Vector3D, 0, 100)
+	for i := 0; i < 100; i++ {
+		phi := 2.0 * math.Pi * float64(i) / 100.0
+		horizontal = append(horizontal, measurement.Vector3D{X: 20.0 * math.Cos(phi), Y: 20.0 * math.Sin(phi), Z: -40.0})
+	}
+
+	softIron := [3][3]float64{{1.2, 0.1, 0.0}, {0.1, 0.8, 0.0}, {0.0, 0.0, 1.0}}
+	hardIron := measurement.Vector3D{X: 5.0, Y: -3.0, Z: 0.0}
+
+	c, err := FitMagneto(distort(horizontal, softIron, hardIron))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if !c.Planar {
+		t.Errorf("readings of a single rotation axis not fitted as planar")
+	}
+
+	if math.Abs(c.HardIron.X-hardIron.X) > testTolerance || math.Abs(c.HardIron.Y-hardIron.Y) > testTolerance {
+		t.Errorf("hard-iron: got %+v, want %+v", c.HardIron, hardIron)
+	}
+
+	// The normal component is left as is
+	if math.Abs(c.SoftIron[2][2]-1.0) > testTolerance || math.Abs(c.HardIron.Z) > testTolerance {
+		t.Errorf("normal axis changed: soft-iron %f, hard-iron %f", c.SoftIron[2][2], c.HardIron.Z)
+	}
+
+	if c.ResidualRMS > testTolerance {
+		t.Errorf("residual RMS: got %f, want 0", c.ResidualRMS)
+	}
+}
+
+func TestFitMagnetoCoverage(t *testing.T) {
+	// Readings along a single direction do not describe an ellipsoid
+	line := make([]measurement.Vector3D, 0, 50)
+	for i := 0; i < 50; i++ {
+		line = append(line, measurement.Vector3D{X: float64(i), Y: 2.0 * float64(i), Z: 1.0})
+	}
+
+	_, err := FitMagneto(line)
+	if err != ErrInsufficientCoverage {
+		t.Errorf("got error %v, want %v", err, ErrInsufficientCoverage)
+	}
+}
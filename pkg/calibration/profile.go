@@ -0,0 +1,122 @@
+package calibration
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+)
+
+// ErrNoProfile is returned when the store has no calibration profile for a device
+var ErrNoProfile = errors.New("no calibration profile for device")
+
+// Profile holds the calibration of a physical sensor. Alignment rotates sensor frame readings into the body frame.
+// Corrections are applied in sensor frame, before the alignment.
+type Profile struct {
+	DeviceID  string                  `json:"device_id"`
+	Magneto   *MagnetoCalibration     `json:"magneto,omitempty"`
+	Accelero  *AcceleroCalibration    `json:"accelero,omitempty"`
+	Gyro      *GyroCalibration        `json:"gyro,omitempty"`
+	Alignment *measurement.Quaternion `json:"alignment,omitempty"`
+}
+
+// NewProfile creates an empty calibration profile for a device
+func NewProfile(deviceid string) *Profile {
+	return &Profile{
+		DeviceID: deviceid,
+	}
+}
+
+func (p Profile) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Calibration profile of device %s\n", p.DeviceID)
+	if p.Magneto != nil {
+		fmt.Fprint(&b, p.Magneto)
+	}
+	if p.Accelero != nil {
+		fmt.Fprint(&b, p.Accelero)
+	}
+	if p.Gyro != nil {
+		fmt.Fprint(&b, p.Gyro)
+	}
+	if p.Alignment != nil {
+		a := p.Alignment.GetAsEuler()
+		fmt.Fprintf(&b, "Alignment: roll %.4f, pitch %.4f, yaw %.4f rad\n", a.Roll, a.Pitch, a.Yaw)
+	}
+
+	return b.String()
+}
+
+// ProfileStore keeps calibration profiles as JSON files named after the device ID in a directory
+type ProfileStore struct {
+	Dir string
+}
+
+// NewProfileStore creates a profile store in the given directory
+func NewProfileStore(dir string) *ProfileStore {
+	return &ProfileStore{
+		Dir: dir,
+	}
+}
+
+// getPath returns the file of the profile of a device
+func (s ProfileStore) getPath(deviceid string) (string, error) {
+	if deviceid == "" || strings.ContainsAny(deviceid, `/\.`) {
+		return "", fmt.Errorf("invalid device ID: %q", deviceid)
+	}
+
+	return filepath.Join(s.Dir, deviceid+".json"), nil
+}
+
+// Load returns the profile of a device, or ErrNoProfile if the store has none
+func (s ProfileStore) Load(deviceid string) (*Profile, error) {
+	path, err := s.getPath(deviceid)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoProfile
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p := Profile{}
+	err = json.Unmarshal(data, &p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid calibration profile %s: %s", path, err.Error())
+	}
+
+	if p.DeviceID != deviceid {
+		return nil, fmt.Errorf("calibration profile %s belongs to device %s", path, p.DeviceID)
+	}
+
+	return &p, nil
+}
+
+// Save writes the profile into the store, replacing the previous profile of the device
+func (s ProfileStore) Save(p Profile) error {
+	path, err := s.getPath(p.DeviceID)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(s.Dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
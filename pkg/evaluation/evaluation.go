@@ -0,0 +1,210 @@
+// Package evaluation compares the orientation estimate of a software filter with a reference, e.g. the
+// orientation output of the chip
+package evaluation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+)
+
+// Config holds the settings of the evaluation. The estimate is converged from the first sample after which the
+// angular error stays below ConvergenceThreshold (radians) for ConvergenceHold seconds. With RemoveHeadingOffset
+// the mean heading difference of the converged part is removed before the errors are computed.
+type Config struct {
+	ConvergenceThreshold float64
+	ConvergenceHold      float64
+	RemoveHeadingOffset  bool
+}
+
+// DefaultConfig returns a 5 degree convergence threshold held for 1 second, heading offset kept
+func DefaultConfig() Config {
+	return Config{
+		ConvergenceThreshold: 5.0 * math.Pi / 180.0,
+		ConvergenceHold:      1.0,
+		RemoveHeadingOffset:  false,
+	}
+}
+
+// Report holds the accuracy of an estimate. Errors are in radians and are computed over the converged part, or
+// over all samples if the estimate does not converge. Heading offset and drift are the mean and the slope
+// (radians / sec) of the heading error, the rotation around the vertical axis between estimate and reference.
+type Report struct {
+	Samples         int
+	Converged       bool
+	ConvergenceTime float64
+	AngleRMS        float64
+	AngleMax        float64
+	RollRMSE        float64
+	PitchRMSE       float64
+	YawRMSE         float64
+	DriftRate       float64
+	HeadingOffset   float64
+	AngularError    []float64
+}
+
+// wrapAngle returns the angle in (-pi, pi]
+func wrapAngle(angle float64) float64 {
+	return math.Atan2(math.Sin(angle), math.Cos(angle))
+}
+
+// getHeadingError returns the rotation of the estimate around the vertical axis relative to the reference. Unlike
+// the yaw difference it is well defined near gimbal lock.
+func getHeadingError(reference, estimate measurement.Quaternion) float64 {
+	e := estimate.Multiply(reference.Conjugate())
+
+	// Take the shorter of the two equivalent rotations
+	if e.Q0 < 0.0 {
+		e.Scale(-1.0)
+	}
+
+	return e.GetAsRotationVector().Z
+}
+
+// getConvergenceIndex returns the first sample after which the error stays below the threshold for the hold
+// time, or -1
+func getConvergenceIndex(errors, timestamps []float64, cfg Config) int {
+	candidate := -1
+
+	for idx, e := range errors {
+		if e >= cfg.ConvergenceThreshold {
+			candidate = -1
+			continue
+		}
+
+		if candidate == -1 {
+			candidate = idx
+		}
+		if timestamps[idx]-timestamps[candidate] >= cfg.ConvergenceHold {
+			return candidate
+		}
+	}
+
+	return -1
+}
+
+// Evaluate compares the estimate with the reference sample by sample, both are expressed in NWU first. Series of
+// unknown frame give measurement.ErrUnknownFrame. The timestamps are in seconds, the series are cut to the
+// shortest one.
+func Evaluate(reference, estimate []measurement.Quaternion, timestamps []float64, cfg Config) (*Report, error) {
+	n := len(reference)
+	if len(estimate) < n {
+		n = len(estimate)
+	}
+	if len(timestamps) < n {
+		n = len(timestamps)
+	}
+	if n == 0 {
+		return nil, errors.New("no samples to evaluate")
+	}
+
+	ref := make([]measurement.Quaternion, n)
+	est := make([]measurement.Quaternion, n)
+	for idx := 0; idx < n; idx++ {
+		var err error
+		ref[idx], err = reference[idx].InFrame(measurement.FrameNWU)
+		if err != nil {
+			return nil, err
+		}
+		est[idx], err = estimate[idx].InFrame(measurement.FrameNWU)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r := Report{
+		Samples:      n,
+		AngularError: make([]float64, 0, n),
+	}
+	for idx := 0; idx < n; idx++ {
+		r.AngularError = append(r.AngularError, ref[idx].AngularDistance(est[idx]))
+	}
+
+	start := getConvergenceIndex(r.AngularError, timestamps, cfg)
+	r.Converged = start != -1
+	if r.Converged {
+		r.ConvergenceTime = timestamps[start] - timestamps[0]
+	} else {
+		start = 0
+		r.ConvergenceTime = math.NaN()
+	}
+
+	// Circular mean of the heading difference
+	var sin, cos float64
+	for idx := start; idx < n; idx++ {
+		d := getHeadingError(ref[idx], est[idx])
+		sin += math.Sin(d)
+		cos += math.Cos(d)
+	}
+	r.HeadingOffset = math.Atan2(sin, cos)
+
+	if cfg.RemoveHeadingOffset {
+		offset := measurement.NewQuaternionFromAxisAngle(measurement.Vector3D{X: 0.0, Y: 0.0, Z: 1.0}, -r.HeadingOffset)
+		offset.Frame = measurement.FrameNWU
+		for idx := range est {
+			est[idx] = offset.Multiply(est[idx])
+			r.AngularError[idx] = ref[idx].AngularDistance(est[idx])
+		}
+	}
+
+	// Errors and drift over the converged part, the heading error is unwrapped for the drift
+	count := float64(n - start)
+	var sumT, sumY, sumTT, sumTY, previous, unwrapped float64
+	for idx := start; idx < n; idx++ {
+		e := est[idx].GetAsEuler()
+		f := ref[idx].GetAsEuler()
+
+		roll := wrapAngle(e.Roll - f.Roll)
+		pitch := wrapAngle(e.Pitch - f.Pitch)
+		yaw := wrapAngle(e.Yaw - f.Yaw)
+		heading := getHeadingError(ref[idx], est[idx])
+
+		r.RollRMSE += roll * roll / count
+		r.PitchRMSE += pitch * pitch / count
+		r.YawRMSE += yaw * yaw / count
+		r.AngleRMS += r.AngularError[idx] * r.AngularError[idx] / count
+		r.AngleMax = math.Max(r.AngleMax, r.AngularError[idx])
+
+		if idx == start {
+			unwrapped = heading
+		} else {
+			unwrapped += wrapAngle(heading - previous)
+		}
+		previous = heading
+
+		t := timestamps[idx] - timestamps[start]
+		sumT += t
+		sumY += unwrapped
+		sumTT += t * t
+		sumTY += t * unwrapped
+	}
+
+	r.RollRMSE = math.Sqrt(r.RollRMSE)
+	r.PitchRMSE = math.Sqrt(r.PitchRMSE)
+	r.YawRMSE = math.Sqrt(r.YawRMSE)
+	r.AngleRMS = math.Sqrt(r.AngleRMS)
+
+	if denominator := count*sumTT - sumT*sumT; denominator > 0.0 {
+		r.DriftRate = (count*sumTY - sumT*sumY) / denominator
+	}
+
+	return &r, nil
+}
+
+func (r Report) String() string {
+	convergence := "not converged"
+	if r.Converged {
+		convergence = fmt.Sprintf("converged in %.2f sec", r.ConvergenceTime)
+	}
+
+	return fmt.Sprintf("Filter accuracy (%d samples, %s)\n"+
+		"Angular error RMS: %.3f, max: %.3f degree\n"+
+		"RMSE roll: %.3f, pitch: %.3f, yaw: %.3f degree\n"+
+		"Heading offset: %.3f degree, drift: %.3f degree / min\n",
+		r.Samples, convergence,
+		r.AngleRMS*180.0/math.Pi, r.AngleMax*180.0/math.Pi,
+		r.RollRMSE*180.0/math.Pi, r.PitchRMSE*180.0/math.Pi, r.YawRMSE*180.0/math.Pi,
+		r.HeadingOffset*180.0/math.Pi, r.DriftRate*60.0*180.0/math.Pi)
+}
@@ -0,0 +1,157 @@
+package evaluation
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+)
+
+const (
+	testFrequency = 100.0
+	testDuration  = 20.0
+)
+
+// getReference returns a rolling, pitching and turning orientation series in NWU and its timestamps
+func getReference() ([]measurement.Quaternion, []float64) {
+	n := int(testDuration * testFrequency)
+	orientations := make([]measurement.Quaternion, 0, n)
+	timestamps := make([]float64, 0, n)
+
+	for idx := 0; idx < n; idx++ {
+		t := float64(idx) / testFrequency
+		e := measurement.EulerAngles{Roll: 0.2 * math.Sin(t), Pitch: 0.1 * math.Cos(0.5*t), Yaw: 0.3 * t, Frame: measurement.FrameNWU}
+		orientations = append(orientations, e.GetAsQuaternion())
+		timestamps = append(timestamps, t)
+	}
+
+	return orientations, timestamps
+}
+
+// rotate returns the orientation rotated around a world axis
+func rotate(q measurement.Quaternion, axis measurement.Vector3D, angle float64) measurement.Quaternion {
+	r := measurement.NewQuaternionFromAxisAngle(axis, angle)
+	r.Frame = q.Frame
+
+	result := r.Multiply(q)
+	result.Frame = q.Frame
+
+	return result
+}
+
+func TestEvaluate(t *testing.T) {
+	const (
+		convergence = 2.0
+		offset      = 2.0 * math.Pi / 180.0
+		drift       = 0.05 * math.Pi / 180.0
+	)
+
+	// The estimate is off by 30 degree in roll until it converges, then it has a constant heading offset and drifts
+	reference, timestamps := getReference()
+	estimate := make([]measurement.Quaternion, 0, len(reference))
+	headingErrors := make([]float64, 0, len(reference))
+	for idx, q := range reference {
+		if timestamps[idx] < convergence {
+			estimate = append(estimate, rotate(q, measurement.Vector3D{X: 1.0}, 30.0*math.Pi/180.0))
+			continue
+		}
+
+		e := offset + drift*(timestamps[idx]-convergence)
+		estimate = append(estimate, rotate(q, measurement.Vector3D{Z: 1.0}, e))
+		headingErrors = append(headingErrors, e)
+	}
+
+	var mean, rms float64
+	for _, e := range headingErrors {
+		mean += e / float64(len(headingErrors))
+		rms += e * e / float64(len(headingErrors))
+	}
+	rms = math.Sqrt(rms)
+
+	r, err := Evaluate(reference, estimate, timestamps, DefaultConfig())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if !r.Converged || math.Abs(r.ConvergenceTime-convergence) > 1e-9 {
+		t.Errorf("convergence: got %t after %f sec, want %f sec", r.Converged, r.ConvergenceTime, convergence)
+	}
+
+	// Only the heading differs after convergence, it is all yaw error
+	for _, c := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"angle RMS", r.AngleRMS, rms},
+		{"angle max", r.AngleMax, headingErrors[len(headingErrors)-1]},
+		{"roll RMSE", r.RollRMSE, 0.0},
+		{"pitch RMSE", r.PitchRMSE, 0.0},
+		{"yaw RMSE", r.YawRMSE, rms},
+		{"drift", r.DriftRate, drift},
+		{"heading offset", r.HeadingOffset, mean},
+	} {
+		if math.Abs(c.got-c.want) > 1e-6 {
+			t.Errorf("%s: got %.9f, want %.9f", c.name, c.got, c.want)
+		}
+	}
+
+	if len(r.AngularError) != len(reference) || math.Abs(r.AngularError[0]-30.0*math.Pi/180.0) > 1e-9 {
+		t.Errorf("angular error series: got %d samples starting with %f", len(r.AngularError), r.AngularError[0])
+	}
+
+	// Without the heading offset only the drift around it remains
+	cfg := DefaultConfig()
+	cfg.RemoveHeadingOffset = true
+	r, err = Evaluate(reference, estimate, timestamps, cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	var std float64
+	for _, e := range headingErrors {
+		std += (e - mean) * (e - mean) / float64(len(headingErrors))
+	}
+	std = math.Sqrt(std)
+
+	if math.Abs(r.AngleRMS-std) > 1e-6 || math.Abs(r.DriftRate-drift) > 1e-6 {
+		t.Errorf("heading offset removed: got RMS %.9f, drift %.9f, want %.9f, %.9f", r.AngleRMS, r.DriftRate, std, drift)
+	}
+}
+
+func TestEvaluateNotConverged(t *testing.T) {
+	reference, timestamps := getReference()
+	estimate := make([]measurement.Quaternion, 0, len(reference))
+	for _, q := range reference {
+		estimate = append(estimate, rotate(q, measurement.Vector3D{Y: 1.0}, 10.0*math.Pi/180.0))
+	}
+
+	r, err := Evaluate(reference, estimate, timestamps, DefaultConfig())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	// The errors are computed over the whole series
+	if r.Converged || !math.IsNaN(r.ConvergenceTime) {
+		t.Errorf("convergence: got %t after %f sec, want not converged", r.Converged, r.ConvergenceTime)
+	}
+	if math.Abs(r.AngleRMS-10.0*math.Pi/180.0) > 1e-9 || r.Samples != len(reference) {
+		t.Errorf("angle RMS: got %f over %d samples, want 10 degree over %d", r.AngleRMS*180.0/math.Pi, r.Samples, len(reference))
+	}
+}
+
+func TestEvaluateUnknownFrame(t *testing.T) {
+	reference, timestamps := getReference()
+	estimate := append([]measurement.Quaternion(nil), reference...)
+	estimate[0].Frame = measurement.FrameUnknown
+
+	_, err := Evaluate(reference, estimate, timestamps, DefaultConfig())
+	if !errors.Is(err, measurement.ErrUnknownFrame) {
+		t.Errorf("got error %v, want %v", err, measurement.ErrUnknownFrame)
+	}
+
+	_, err = Evaluate(reference, estimate, nil, DefaultConfig())
+	if err == nil {
+		t.Errorf("no error without samples")
+	}
+}
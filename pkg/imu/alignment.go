@@ -0,0 +1,100 @@
+package imu
+
+import (
+	"errors"
+	"math"
+
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+)
+
+// GetMeanVector returns the average of the non-empty vectors, or an empty vector if there is none
+func GetMeanVector(vectors []measurement.Vector3D) measurement.Vector3D {
+	result := measurement.Vector3D{X: 0.0, Y: 0.0, Z: 0.0, Frame: measurement.FrameSensor}
+	count := 0
+
+	for _, v := range vectors {
+		if v.IsEmpty() {
+			continue
+		}
+
+		result.X += v.X
+		result.Y += v.Y
+		result.Z += v.Z
+		count++
+	}
+
+	if count > 0 {
+		result.Scale(1.0 / float64(count))
+	}
+
+	return result
+}
+
+// normalized returns the unit vector of the same direction
+func normalized(v measurement.Vector3D) measurement.Vector3D {
+	v.Scale(1.0 / v.Norm())
+	return v
+}
+
+// GetTRIADAlignment returns the orientation relative to the NWU frame of magnetic north from the accelerometer
+// and magnetometer readings of a sensor at rest, using the TRIAD method. Gravity is the primary vector, so the
+// tilt is exact and the magnetometer only sets the heading.
+func GetTRIADAlignment(accelero, magneto measurement.Vector3D) (measurement.Quaternion, error) {
+	if accelero.IsEmpty() || magneto.IsEmpty() {
+		return measurement.Quaternion{}, errors.New("alignment needs accelerometer and magnetometer readings")
+	}
+
+	// Body triad: up, west (up x magnetic field) and south (up x west)
+	up := normalized(accelero)
+	west := up.Cross(magneto)
+	if west.Norm() < 1e-6*magneto.Norm() {
+		return measurement.Quaternion{}, errors.New("magnetic field is parallel to gravity")
+	}
+	west = normalized(west)
+	south := up.Cross(west)
+
+	// The same triad is the Z, Y and -X axis of the NWU frame, the rows of the body to NWU rotation follow
+	r := measurement.RotationMatrix{
+		M: [3][3]float64{
+			{-south.X, -south.Y, -south.Z},
+			{west.X, west.Y, west.Z},
+			{up.X, up.Y, up.Z},
+		},
+		Frame: measurement.FrameNWU,
+	}
+
+	q := r.GetAsQuaternion()
+	q.Frame = measurement.FrameNWU
+
+	return q, nil
+}
+
+// GetTiltAlignment returns the orientation with zero yaw relative to the NWU frame from the accelerometer reading
+// of a sensor at rest, for logs without magnetometer readings
+func GetTiltAlignment(accelero measurement.Vector3D) (measurement.Quaternion, error) {
+	if accelero.IsEmpty() {
+		return measurement.Quaternion{}, errors.New("alignment needs accelerometer readings")
+	}
+
+	e := measurement.EulerAngles{
+		Roll:  math.Atan2(accelero.Y, accelero.Z),
+		Pitch: math.Atan2(-accelero.X, math.Hypot(accelero.Y, accelero.Z)),
+		Yaw:   0.0,
+		Frame: measurement.FrameNWU,
+	}
+
+	return e.GetAsQuaternion(), nil
+}
+
+// GetInitialAlignment returns the coarse initial orientation relative to the NWU frame from the averaged readings
+// of a stationary window. Without magnetometer readings only the tilt is aligned.
+func GetInitialAlignment(accelero, magneto []measurement.Vector3D) (measurement.Quaternion, error) {
+	a := GetMeanVector(accelero)
+	m := GetMeanVector(magneto)
+
+	if m.IsEmpty() {
+		return GetTiltAlignment(a)
+	}
+
+	return GetTRIADAlignment(a, m)
+}
@@ -0,0 +1,78 @@
+package imu
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+)
+
+func TestTRIADAlignment(t *testing.T) {
+	tests := []measurement.EulerAngles{
+		{Roll: 0.0, Pitch: 0.0, Yaw: 0.0},
+		{Roll: 0.35, Pitch: -0.2, Yaw: 1.1},
+		{Roll: -2.5, Pitch: 0.6, Yaw: -2.9},
+		{Roll: 0.1, Pitch: 1.4, Yaw: 0.5},
+	}
+
+	for _, e := range tests {
+		e.Frame = measurement.FrameNWU
+		want := e.GetAsQuaternion()
+		accelero, magneto := getStillReadings(want)
+
+		got, err := GetTRIADAlignment(accelero, magneto)
+		if err != nil {
+			t.Errorf("%v: unexpected error: %s", e, err.Error())
+			continue
+		}
+
+		if got.Frame != measurement.FrameNWU {
+			t.Errorf("%v: got frame %s, want NWU", e, got.Frame)
+		}
+		if d := got.AngularDistance(want); d > 1e-6 {
+			t.Errorf("%v: got %v, %g rad off", e, got.GetAsEuler(), d)
+		}
+	}
+}
+
+func TestInitialAlignment(t *testing.T) {
+	want := getTestAttitude()
+	accelero, magneto := getStillReadings(want)
+
+	// Noisy readings of the still sensor average out
+	var accelerometer, magnetometer []measurement.Vector3D
+	for i := 0; i < 100; i++ {
+		noise := 0.01 * math.Sin(float64(i))
+		accelerometer = append(accelerometer, measurement.Vector3D{X: accelero.X + noise, Y: accelero.Y - noise, Z: accelero.Z})
+		magnetometer = append(magnetometer, measurement.Vector3D{X: magneto.X, Y: magneto.Y + 0.001*noise, Z: magneto.Z})
+	}
+
+	got, err := GetInitialAlignment(accelerometer, magnetometer)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if d := got.AngularDistance(want); d > 0.05*math.Pi/180.0 {
+		t.Errorf("alignment: got %v, %.3f degree from %v", got.GetAsEuler(), d*180.0/math.Pi, want.GetAsEuler())
+	}
+
+	// Without magnetometer readings only the tilt is aligned
+	got, err = GetInitialAlignment(accelerometer, make([]measurement.Vector3D, len(accelerometer)))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	angles, wantAngles := got.GetAsEuler(), want.GetAsEuler()
+	if math.Abs(angles.Roll-wantAngles.Roll) > 1e-3 || math.Abs(angles.Pitch-wantAngles.Pitch) > 1e-3 || math.Abs(angles.Yaw) > 1e-9 {
+		t.Errorf("tilt alignment: got %v, want roll %f, pitch %f, yaw 0", angles, wantAngles.Roll, wantAngles.Pitch)
+	}
+}
+
+func TestTRIADAlignmentDegenerate(t *testing.T) {
+	// A magnetic field along gravity gives no heading
+	if _, err := GetTRIADAlignment(stillAccelero, measurement.Vector3D{Z: -0.5}); err == nil {
+		t.Errorf("field parallel to gravity: no error")
+	}
+
+	if _, err := GetTRIADAlignment(measurement.Vector3D{}, measurement.Vector3D{X: 0.2, Z: -0.45}); err == nil {
+		t.Errorf("no accelerometer reading: no error")
+	}
+}
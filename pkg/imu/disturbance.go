@@ -0,0 +1,58 @@
+package imu
+
+import (
+	"math"
+
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+)
+
+// standardGravity is the magnitude of the specific force in m / sec^2 of a sensor at rest
+const standardGravity = 9.80665
+
+// DisturbanceDetector flags magnetometer readings whose magnitude or dip angle deviate from the undisturbed earth
+// field, e.g. next to steel objects. The dip angle is only checked while the accelerometer senses gravity alone.
+type DisturbanceDetector struct {
+	FieldStrength        float64
+	Inclination          float64
+	MagnitudeTolerance   float64
+	InclinationTolerance float64
+	AcceleroTolerance    float64
+}
+
+// NewDisturbanceDetector creates a detector for the expected field strength (in the units of the readings) and
+// inclination (radians, down positive) with the default tolerances
+func NewDisturbanceDetector(fieldstrength, inclination float64) *DisturbanceDetector {
+	return &DisturbanceDetector{
+		FieldStrength:        fieldstrength,
+		Inclination:          inclination,
+		MagnitudeTolerance:   0.1,
+		InclinationTolerance: 10.0 * math.Pi / 180.0,
+		AcceleroTolerance:    0.1,
+	}
+}
+
+// GetInclination returns the dip angle of the magnetometer reading in radians, down positive, taking the
+// accelerometer reading as up direction
+func GetInclination(accelero, magneto measurement.Vector3D) float64 {
+	cos := magneto.Dot(accelero) / (magneto.Norm() * accelero.Norm())
+
+	return -math.Asin(math.Max(-1.0, math.Min(1.0, cos)))
+}
+
+// IsDisturbed checks the magnetometer reading against the expected field, empty readings are not disturbed
+func (d DisturbanceDetector) IsDisturbed(accelero, magneto measurement.Vector3D) bool {
+	if magneto.IsEmpty() {
+		return false
+	}
+
+	if math.Abs(magneto.Norm()-d.FieldStrength) > d.MagnitudeTolerance*d.FieldStrength {
+		return true
+	}
+
+	// Linear acceleration tilts the sensed up direction, the dip angle is not reliable then
+	if accelero.IsEmpty() || math.Abs(accelero.Norm()-standardGravity) > d.AcceleroTolerance*standardGravity {
+		return false
+	}
+
+	return math.Abs(GetInclination(accelero, magneto)-d.Inclination) > d.InclinationTolerance
+}
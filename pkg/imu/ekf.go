@@ -0,0 +1,328 @@
+package imu
+
+import (
+	"math"
+
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+)
+
+// ekfStates is the size of the error state: 3 attitude errors and 3 gyroscope bias errors
+const ekfStates = 6
+
+// UncertaintyEstimator is implemented by filters that track the uncertainty of their orientation estimate
+type UncertaintyEstimator interface {
+	// GetEulerSigma returns the standard deviation of the roll, pitch and yaw estimate in radians
+	GetEulerSigma() measurement.EulerAngles
+}
+
+// ExtendedKalmanAHRS is a multiplicative extended Kalman filter estimating the orientation relative to the NWU frame of
+// magnetic north and gravity, and the gyroscope bias. The error state is the attitude error in the sensor frame and the
+// gyroscope bias error.
+type ExtendedKalmanAHRS struct {
+	SamplingFrequency float64
+	Quaternion        measurement.Quaternion
+	GyroBias          measurement.Vector3D
+	Covariance        [ekfStates][ekfStates]float64
+	GyroNoise         float64 // gyroscope noise density in radians / sec / sqrt(Hz)
+	BiasNoise         float64 // gyroscope bias random walk in radians / sec^2 / sqrt(Hz)
+	AcceleroNoise     float64 // standard deviation of the normalised accelerometer reading
+	MagnetoNoise      float64 // standard deviation of the normalised magnetometer reading
+	AttitudeSigma     float64 // initial standard deviation of the attitude error in radians
+	BiasSigma         float64 // initial standard deviation of the gyroscope bias in radians / sec
+}
+
+func NewExtendedKalmanAHRS(samplingfreq, gyronoise, biasnoise, acceleronoise, magnetonoise, attitudesigma, biassigma float64) *ExtendedKalmanAHRS {
+	e := ExtendedKalmanAHRS{
+		SamplingFrequency: samplingfreq,
+		GyroNoise:         gyronoise,
+		BiasNoise:         biasnoise,
+		AcceleroNoise:     acceleronoise,
+		MagnetoNoise:      magnetonoise,
+		AttitudeSigma:     attitudesigma,
+		BiasSigma:         biassigma,
+	}
+	e.Reset()
+
+	return &e
+}
+
+func init() {
+	defaults := map[string]float64{
+		"gyro_noise": 0.005,
+		"bias_noise": 0.0001,
+		"acc_noise":  0.05,
+		"mag_noise":  0.1,
+		// Attitude is unknown at start, the bias is within the range of MEMS gyroscopes
+		"attitude_sigma": 1.0,
+		"bias_sigma":     0.05,
+	}
+
+	err := RegisterFilter("ekf", func(samplingfreq float64, params map[string]float64) (OrientationFilter, error) {
+		p, err := getParams(defaults, params)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewExtendedKalmanAHRS(samplingfreq, p["gyro_noise"], p["bias_noise"], p["acc_noise"], p["mag_noise"],
+			p["attitude_sigma"], p["bias_sigma"]), nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// GetQuaternion returns the current orientation estimate
+func (e *ExtendedKalmanAHRS) GetQuaternion() measurement.Quaternion {
+	return e.Quaternion
+}
+
+// GetEulerSigma returns the standard deviation of the roll, pitch and yaw estimate in radians
+func (e *ExtendedKalmanAHRS) GetEulerSigma() measurement.EulerAngles {
+	angles := e.Quaternion.GetAsEuler()
+
+	// Jacobian of the roll-pitch-yaw angles with respect to a small rotation in the sensor frame
+	sr, cr := math.Sin(angles.Roll), math.Cos(angles.Roll)
+	tp, cp := math.Tan(angles.Pitch), math.Cos(angles.Pitch)
+	w := [3][3]float64{
+		{1.0, sr * tp, cr * tp},
+		{0.0, cr, -sr},
+		{0.0, sr / cp, cr / cp},
+	}
+
+	variance := [3]float64{}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				variance[i] += w[i][j] * e.Covariance[j][k] * w[i][k]
+			}
+		}
+	}
+
+	return measurement.EulerAngles{
+		Roll:  math.Sqrt(variance[0]),
+		Pitch: math.Sqrt(variance[1]),
+		Yaw:   math.Sqrt(variance[2]),
+		Frame: e.Quaternion.Frame,
+	}
+}
+
+// Reset sets the filter back to identity orientation, zero bias and the initial covariance
+func (e *ExtendedKalmanAHRS) Reset() {
+	e.Quaternion = measurement.Quaternion{Q0: 1.0, Q1: 0.0, Q2: 0.0, Q3: 0.0, Frame: measurement.FrameNWU}
+	e.GyroBias = measurement.Vector3D{X: 0.0, Y: 0.0, Z: 0.0, Frame: measurement.FrameSensor}
+	e.Covariance = [ekfStates][ekfStates]float64{}
+
+	for i := 0; i < 3; i++ {
+		e.Covariance[i][i] = math.Pow(e.AttitudeSigma, 2)
+		e.Covariance[i+3][i+3] = math.Pow(e.BiasSigma, 2)
+	}
+}
+
+// Initialize resets the filter and starts it from the given orientation
+func (e *ExtendedKalmanAHRS) Initialize(q measurement.Quaternion) {
+	e.Reset()
+	e.Quaternion = q
+	e.Quaternion.Frame = measurement.FrameNWU
+}
+
+// ApplyPrewarm starts the filter from the coarse alignment of a stationary subset of the data
+func (e *ExtendedKalmanAHRS) ApplyPrewarm(gyro, accelero, magneto []measurement.Vector3D, dt []float64) {
+	applyPrewarm(e, gyro, accelero, magneto, dt)
+}
+
+// Update predicts with the gyroscope and corrects with the accelerometer and, if present, the magnetometer.
+// Gyroscope readings are expected in radians / sec, dt is the time elapsed since the previous update in seconds.
+func (e *ExtendedKalmanAHRS) Update(gyro, accelero, magneto measurement.Vector3D, dt float64) {
+	e.predict(gyro, samplePeriod(dt, 1.0/e.SamplingFrequency))
+
+	if accelero.IsEmpty() {
+		return
+	}
+
+	// Gravity reaction points up in the navigation frame
+	accelero.Scale(1.0 / accelero.Norm())
+	up := measurement.Vector3D{X: 0.0, Y: 0.0, Z: 1.0}
+	e.correct(accelero, e.Quaternion.Conjugate().Rotate(up), e.AcceleroNoise)
+
+	if magneto.IsEmpty() {
+		return
+	}
+
+	// Reference direction of Earth's magnetic field, north and vertical components of the rotated reading
+	magneto.Scale(1.0 / magneto.Norm())
+	h := e.Quaternion.Rotate(magneto)
+	reference := measurement.Vector3D{X: math.Sqrt(h.X*h.X + h.Y*h.Y), Y: 0.0, Z: h.Z}
+	e.correct(magneto, e.Quaternion.Conjugate().Rotate(reference), e.MagnetoNoise)
+}
+
+// predict integrates the bias corrected angular rate and propagates the covariance
+func (e *ExtendedKalmanAHRS) predict(gyro measurement.Vector3D, dt float64) {
+	rate := measurement.Vector3D{X: gyro.X - e.GyroBias.X, Y: gyro.Y - e.GyroBias.Y, Z: gyro.Z - e.GyroBias.Z}
+	step := rate
+	step.Scale(dt)
+
+	frame := e.Quaternion.Frame
+	e.Quaternion = e.Quaternion.Multiply(measurement.NewQuaternionFromRotationVector(step))
+	e.Quaternion.Normalize()
+	e.Quaternion.Frame = frame
+
+	// Discrete error state transition: attitude error rotates against the rate and integrates the bias error
+	phi := getIdentity()
+	s := getSkew(rate)
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			phi[i][j] -= s[i][j] * dt
+		}
+		phi[i][i+3] = -dt
+	}
+
+	var p [ekfStates][ekfStates]float64
+	for i := 0; i < ekfStates; i++ {
+		for j := 0; j < ekfStates; j++ {
+			for k := 0; k < ekfStates; k++ {
+				for l := 0; l < ekfStates; l++ {
+					p[i][j] += phi[i][k] * e.Covariance[k][l] * phi[j][l]
+				}
+			}
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		p[i][i] += math.Pow(e.GyroNoise, 2) * dt
+		p[i+3][i+3] += math.Pow(e.BiasNoise, 2) * dt
+	}
+
+	e.Covariance = p
+}
+
+// correct fuses a measured direction in the sensor frame with its expected value
+func (e *ExtendedKalmanAHRS) correct(measured, expected measurement.Vector3D, sigma float64) {
+	// Measurement Jacobian with respect to the attitude error, the bias is not observed directly
+	var h [3][ekfStates]float64
+	s := getSkew(expected)
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			h[i][j] = s[i][j]
+		}
+	}
+
+	// PHt = P * H^T and S = H * P * H^T + R
+	var pht [ekfStates][3]float64
+	for i := 0; i < ekfStates; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < ekfStates; k++ {
+				pht[i][j] += e.Covariance[i][k] * h[j][k]
+			}
+		}
+	}
+
+	var innovationCov [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < ekfStates; k++ {
+				innovationCov[i][j] += h[i][k] * pht[k][j]
+			}
+		}
+		innovationCov[i][i] += sigma * sigma
+	}
+
+	sinv, ok := getInverse3(innovationCov)
+	if !ok {
+		return
+	}
+
+	// Kalman gain K = PHt * S^-1
+	var k [ekfStates][3]float64
+	for i := 0; i < ekfStates; i++ {
+		for j := 0; j < 3; j++ {
+			for l := 0; l < 3; l++ {
+				k[i][j] += pht[i][l] * sinv[l][j]
+			}
+		}
+	}
+
+	residual := [3]float64{measured.X - expected.X, measured.Y - expected.Y, measured.Z - expected.Z}
+	var dx [ekfStates]float64
+	for i := 0; i < ekfStates; i++ {
+		for j := 0; j < 3; j++ {
+			dx[i] += k[i][j] * residual[j]
+		}
+	}
+
+	// Apply the attitude error multiplicatively and the bias error additively
+	frame := e.Quaternion.Frame
+	e.Quaternion = e.Quaternion.Multiply(measurement.Quaternion{Q0: 1.0, Q1: dx[0] / 2.0, Q2: dx[1] / 2.0, Q3: dx[2] / 2.0})
+	e.Quaternion.Normalize()
+	e.Quaternion.Frame = frame
+	e.GyroBias.X += dx[3]
+	e.GyroBias.Y += dx[4]
+	e.GyroBias.Z += dx[5]
+
+	// Joseph form P = (I - KH) P (I - KH)^T + K R K^T keeps the covariance symmetric and positive
+	ikh := getIdentity()
+	for i := 0; i < ekfStates; i++ {
+		for j := 0; j < ekfStates; j++ {
+			for l := 0; l < 3; l++ {
+				ikh[i][j] -= k[i][l] * h[l][j]
+			}
+		}
+	}
+
+	var p [ekfStates][ekfStates]float64
+	for i := 0; i < ekfStates; i++ {
+		for j := 0; j < ekfStates; j++ {
+			for a := 0; a < ekfStates; a++ {
+				for b := 0; b < ekfStates; b++ {
+					p[i][j] += ikh[i][a] * e.Covariance[a][b] * ikh[j][b]
+				}
+			}
+			for l := 0; l < 3; l++ {
+				p[i][j] += k[i][l] * sigma * sigma * k[j][l]
+			}
+		}
+	}
+
+	e.Covariance = p
+}
+
+// getIdentity returns the identity matrix of the error state size
+func getIdentity() [ekfStates][ekfStates]float64 {
+	var result [ekfStates][ekfStates]float64
+	for i := 0; i < ekfStates; i++ {
+		result[i][i] = 1.0
+	}
+
+	return result
+}
+
+// getSkew returns the cross product matrix of the vector
+func getSkew(v measurement.Vector3D) [3][3]float64 {
+	return [3][3]float64{
+		{0.0, -v.Z, v.Y},
+		{v.Z, 0.0, -v.X},
+		{-v.Y, v.X, 0.0},
+	}
+}
+
+// getInverse3 returns the inverse of a 3x3 matrix, false if it is singular
+func getInverse3(m [3][3]float64) ([3][3]float64, bool) {
+	var result [3][3]float64
+
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	if det == 0.0 {
+		return result, false
+	}
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			// Cofactor of the transposed position, rows and columns taken cyclically
+			a, b := (j+1)%3, (j+2)%3
+			c, d := (i+1)%3, (i+2)%3
+			result[i][j] = (m[a][c]*m[b][d] - m[a][d]*m[b][c]) / det
+		}
+	}
+
+	return result, true
+}
@@ -0,0 +1,42 @@
+package imu
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+)
+
+func TestExtendedKalmanGyroBias(t *testing.T) {
+	const samplingfreq = 100.0
+	bias := measurement.Vector3D{X: 0.02, Y: -0.01, Z: 0.015, Frame: measurement.FrameSensor}
+	want := getTestAttitude()
+	accelero, magneto := getStillReadings(want)
+
+	f, err := NewFilter("ekf", samplingfreq, nil)
+	if err != nil {
+		t.Fatalf("unable to create filter: %s", err.Error())
+	}
+	e := f.(*ExtendedKalmanAHRS)
+	initial := e.GetEulerSigma()
+
+	// The still sensor reads only the bias, the bias states take it over from the attitude error
+	for i := 0; i < 120*samplingfreq; i++ {
+		e.Update(bias, accelero, magneto, 1.0/samplingfreq)
+	}
+
+	got := e.GyroBias
+	if math.Abs(got.X-bias.X) > 2e-4 || math.Abs(got.Y-bias.Y) > 2e-4 || math.Abs(got.Z-bias.Z) > 2e-4 {
+		t.Errorf("gyro bias: got %v, want %v", got, bias)
+	}
+
+	if d := e.GetQuaternion().AngularDistance(want); d > 0.2*math.Pi/180.0 {
+		t.Errorf("orientation: got %v, %.3f degree from %v", e.GetQuaternion().GetAsEuler(), d*180.0/math.Pi, want.GetAsEuler())
+	}
+
+	// The uncertainty of the estimate shrinks from the initial attitude sigma
+	sigma := e.GetEulerSigma()
+	if sigma.Roll >= initial.Roll || sigma.Pitch >= initial.Pitch || sigma.Yaw >= initial.Yaw {
+		t.Errorf("sigma: got %v, want below %v", sigma, initial)
+	}
+}
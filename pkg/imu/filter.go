@@ -0,0 +1,195 @@
+package imu
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+)
+
+// OrientationFilter estimates the orientation of the sensor from gyroscope, accelerometer and magnetometer readings
+type OrientationFilter interface {
+	// Update fuses one sample, gyroscope in radians / sec and dt in seconds. An empty magneto is a missing reading.
+	Update(gyro, accelero, magneto measurement.Vector3D, dt float64)
+	// GetQuaternion returns the current orientation estimate, its Frame is the navigation frame of the filter
+	GetQuaternion() measurement.Quaternion
+	// Reset sets the filter back to its initial state
+	Reset()
+	// Initialize resets the filter and starts it from the given orientation, e.g. from a coarse alignment
+	Initialize(q measurement.Quaternion)
+	// ApplyPrewarm starts the filter from the coarse alignment of a stationary subset of the data before
+	// processing the whole log
+	ApplyPrewarm(gyro, accelero, magneto []measurement.Vector3D, dt []float64)
+}
+
+// FilterFactory creates a filter for the given nominal sampling frequency, unset parameters take their defaults
+type FilterFactory func(samplingfreq float64, params map[string]float64) (OrientationFilter, error)
+
+var (
+	registryLock sync.RWMutex
+	registry     = make(map[string]FilterFactory)
+)
+
+// RegisterFilter makes a filter implementation available by name
+func RegisterFilter(name string, factory FilterFactory) error {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if _, ok := registry[name]; ok {
+		return fmt.Errorf("filter already registered: %s", name)
+	}
+	registry[name] = factory
+
+	return nil
+}
+
+// NewFilter creates a registered filter by name
+func NewFilter(name string, samplingfreq float64, params map[string]float64) (OrientationFilter, error) {
+	registryLock.RLock()
+	factory, ok := registry[name]
+	registryLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown filter: %s, available: %s", name, strings.Join(FilterNames(), ", "))
+	}
+
+	return factory(samplingfreq, params)
+}
+
+// FilterNames returns the names of the registered filters in alphabetical order
+func FilterNames() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// ParseFilterParams parses filter parameters given as "name=value,name=value"
+func ParseFilterParams(s string) (map[string]float64, error) {
+	params := make(map[string]float64)
+
+	for _, chunk := range strings.Split(s, ",") {
+		if strings.TrimSpace(chunk) == "" {
+			continue
+		}
+
+		kv := strings.SplitN(chunk, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid filter parameter: %q", chunk)
+		}
+
+		value, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of filter parameter %s: %w", kv[0], err)
+		}
+		params[strings.TrimSpace(kv[0])] = value
+	}
+
+	return params, nil
+}
+
+// FilterConfig is a filter name with its parameters
+type FilterConfig struct {
+	Name   string
+	Params map[string]float64
+}
+
+// ParseFilterConfig parses a filter configuration given as "name" or "name:name=value,name=value"
+func ParseFilterConfig(s string) (FilterConfig, error) {
+	parts := strings.SplitN(s, ":", 2)
+	c := FilterConfig{
+		Name:   strings.TrimSpace(parts[0]),
+		Params: make(map[string]float64),
+	}
+
+	if c.Name == "" {
+		return c, fmt.Errorf("invalid filter configuration: %q", s)
+	}
+
+	if len(parts) == 2 {
+		params, err := ParseFilterParams(parts[1])
+		if err != nil {
+			return c, err
+		}
+		c.Params = params
+	}
+
+	return c, nil
+}
+
+func (c FilterConfig) String() string {
+	names := make([]string, 0, len(c.Params))
+	for name := range c.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	params := make([]string, 0, len(names))
+	for _, name := range names {
+		params = append(params, name+"="+strconv.FormatFloat(c.Params[name], 'g', 6, 64))
+	}
+
+	if len(params) == 0 {
+		return c.Name
+	}
+
+	return c.Name + ":" + strings.Join(params, ",")
+}
+
+// applyPrewarm initializes the filter with the coarse alignment of the given subset of the data, the filter is
+// left as it is if the alignment fails
+func applyPrewarm(f OrientationFilter, gyro, accelero, magneto []measurement.Vector3D, dt []float64) {
+	q, err := GetInitialAlignment(accelero, magneto)
+	if err != nil {
+		return
+	}
+
+	f.Initialize(q)
+}
+
+// samplePeriod returns the given time step, or the nominal sampling period if it is not usable
+func samplePeriod(dt, nominal float64) float64 {
+	if dt <= 0.0 || math.IsNaN(dt) {
+		return nominal
+	}
+
+	return dt
+}
+
+// checkPositive returns an error if any of the named parameters is not positive
+func checkPositive(params map[string]float64, names ...string) error {
+	for _, name := range names {
+		if !(params[name] > 0.0) {
+			return fmt.Errorf("filter parameter %s must be positive: %g", name, params[name])
+		}
+	}
+
+	return nil
+}
+
+// getParams returns the defaults overridden by the given parameters, unknown parameter names are an error
+func getParams(defaults, params map[string]float64) (map[string]float64, error) {
+	result := make(map[string]float64, len(defaults))
+	for name, value := range defaults {
+		result[name] = value
+	}
+
+	for name, value := range params {
+		if _, ok := defaults[name]; !ok {
+			return nil, fmt.Errorf("unknown filter parameter: %s", name)
+		}
+		result[name] = value
+	}
+
+	return result, nil
+}
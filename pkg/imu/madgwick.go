@@ -9,24 +9,109 @@ import (
 // https://medium.com/@adrien.za/fast-inverse-square-root-in-go-and-javascript-for-fun-6b891e74e5a8
 const magic64 = 0x5FE6EB50C7B537A9
 
+// MagneticReferenceSetter is implemented by filters that can use a known direction of the earth field instead
+// of deriving it from the magnetometer readings
+type MagneticReferenceSetter interface {
+	// SetMagneticReference sets the field direction in the NWU frame of magnetic north
+	SetMagneticReference(reference measurement.Vector3D)
+}
+
+// MadgwickAHRS estimates the orientation relative to the NWU frame of magnetic north and gravity.
+// With Adaptive set, Beta follows a gain schedule: it decays from BetaInit to BetaDef during the first
+// ConvergenceTime seconds, then BetaDef is lowered while the accelerometer magnitude deviates from 1 g
+// (relative to AcceleroTolerance) and while the angular rate is high (relative to RateScale).
 type MadgwickAHRS struct {
 	SamplingFrequency float64
 	Quaternion        measurement.Quaternion
 	BetaDef           float64
 	Beta              float64
+	MagneticReference *measurement.Vector3D
+	Adaptive          bool
+	BetaInit          float64
+	ConvergenceTime   float64
+	AcceleroTolerance float64
+	RateScale         float64
+	Elapsed           float64
 }
 
 func NewMadgwickAHRS(samplingfreq, betadef float64) *MadgwickAHRS {
 	m := MadgwickAHRS{
 		SamplingFrequency: samplingfreq,
 		BetaDef:           betadef,
-		Beta:              betadef,
-		Quaternion:        measurement.Quaternion{Q0: 1.0, Q1: 0.0, Q2: 0.0, Q3: 0.0},
 	}
+	m.Reset()
 
 	return &m
 }
 
+// NewAdaptiveMadgwickAHRS creates a Madgwick filter with gain schedule
+func NewAdaptiveMadgwickAHRS(samplingfreq, betadef, betainit, convergencetime, accelerotolerance, ratescale float64) *MadgwickAHRS {
+	m := NewMadgwickAHRS(samplingfreq, betadef)
+	m.Adaptive = true
+	m.BetaInit = betainit
+	m.ConvergenceTime = convergencetime
+	m.AcceleroTolerance = accelerotolerance
+	m.RateScale = ratescale
+	m.Reset()
+
+	return m
+}
+
+// The registered filter follows the gain schedule by default, madgwick:adaptive=0,beta=2 is the fixed gain filter
+// used before the schedule was introduced
+func init() {
+	err := RegisterFilter("madgwick", func(samplingfreq float64, params map[string]float64) (OrientationFilter, error) {
+		p, err := getParams(map[string]float64{
+			"beta":          0.5,
+			"adaptive":      1.0,
+			"beta_init":     10.0,
+			"convergence":   2.0,
+			"acc_tolerance": 0.1,
+			"rate_scale":    5.0,
+		}, params)
+		if err != nil {
+			return nil, err
+		}
+
+		if p["adaptive"] == 0.0 {
+			return NewMadgwickAHRS(samplingfreq, p["beta"]), nil
+		}
+
+		// The schedule divides by the tolerances and the convergence time, e.g. probed by a parameter search
+		err = checkPositive(p, "beta_init", "convergence", "acc_tolerance", "rate_scale")
+		if err != nil {
+			return nil, err
+		}
+
+		return NewAdaptiveMadgwickAHRS(samplingfreq, p["beta"], p["beta_init"], p["convergence"], p["acc_tolerance"], p["rate_scale"]), nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// GetQuaternion returns the current orientation estimate
+func (m *MadgwickAHRS) GetQuaternion() measurement.Quaternion {
+	return m.Quaternion
+}
+
+// Reset sets the filter back to identity orientation and the default gain, or the initial gain of the schedule
+func (m *MadgwickAHRS) Reset() {
+	m.Beta = m.BetaDef
+	m.Elapsed = 0.0
+	if m.Adaptive {
+		m.Beta = m.BetaInit
+	}
+	m.Quaternion = measurement.Quaternion{Q0: 1.0, Q1: 0.0, Q2: 0.0, Q3: 0.0, Frame: measurement.FrameNWU}
+}
+
+// SetMagneticReference replaces the field direction derived from each reading by the given one, e.g. from a
+// magnetic model. Only its inclination matters, the reference is normalised.
+func (m *MadgwickAHRS) SetMagneticReference(reference measurement.Vector3D) {
+	reference.Scale(1.0 / reference.Norm())
+	m.MagneticReference = &reference
+}
+
 //FastInvSqrt64 returns the inverse square root (quake heuristics) of a given number
 func FastInvSqrt64(n float64) float64 {
 	if n < 0 {
@@ -40,26 +125,51 @@ func FastInvSqrt64(n float64) float64 {
 	return f
 }
 
-//ApplyPrewarm prewarms the filter with a subset of the data
-func (m *MadgwickAHRS) ApplyPrewarm(gyro, accelero, magneto []measurement.Vector3D) {
-	repeats := 1000 / int(len(gyro))
+// Initialize resets the filter and starts it from the given orientation. The aligned filter skips the
+// convergence phase of the gain schedule.
+func (m *MadgwickAHRS) Initialize(q measurement.Quaternion) {
+	m.Reset()
+	m.Quaternion = q
+	m.Quaternion.Frame = measurement.FrameNWU
 
-	for i := 0; i < repeats+1; i++ {
-		for j := 0; j < len(gyro); j++ {
-			m.Update(gyro[j], accelero[j], magneto[j])
-		}
+	if m.Adaptive {
+		m.Elapsed = m.ConvergenceTime
+		m.Beta = m.BetaDef
 	}
 }
 
-// Update is used to update the quaternion if 9DOF is used
-func (m *MadgwickAHRS) Update(gyro, accelero, magneto measurement.Vector3D) {
-	if magneto.IsEmpty() {
-		m.UpdateIMU(gyro, accelero)
+//ApplyPrewarm starts the filter from the coarse alignment of a stationary subset of the data
+func (m *MadgwickAHRS) ApplyPrewarm(gyro, accelero, magneto []measurement.Vector3D, dt []float64) {
+	applyPrewarm(m, gyro, accelero, magneto, dt)
+}
+
+// updateGain sets Beta from the gain schedule for the next update
+func (m *MadgwickAHRS) updateGain(gyro, accelero measurement.Vector3D, dt float64) {
+	if !m.Adaptive {
 		return
 	}
+	m.Elapsed += dt
 
-	// Convert gyroscope degrees / sec to radians / sec
-	gyro.Scale(0.0174533)
+	// Converge fast from the arbitrary initial orientation
+	if m.Elapsed < m.ConvergenceTime {
+		m.Beta = m.BetaDef + (m.BetaInit-m.BetaDef)*(1.0-m.Elapsed/m.ConvergenceTime)
+		return
+	}
+
+	// Linear acceleration corrupts the gravity direction, fast rotation adds centripetal acceleration
+	deviation := math.Abs(accelero.Norm()/standardGravity-1.0) / m.AcceleroTolerance
+	rate := gyro.Norm() / m.RateScale
+	m.Beta = m.BetaDef / ((1.0 + deviation*deviation) * (1.0 + rate*rate))
+}
+
+// Update is used to update the quaternion if 9DOF is used.
+// Gyroscope readings are expected in radians / sec, dt is the time elapsed since the previous update in seconds.
+func (m *MadgwickAHRS) Update(gyro, accelero, magneto measurement.Vector3D, dt float64) {
+	if magneto.IsEmpty() {
+		m.UpdateIMU(gyro, accelero, dt)
+		return
+	}
+	m.updateGain(gyro, accelero, samplePeriod(dt, 1.0/m.SamplingFrequency))
 
 	// Rate of change of quaternion from gyroscope
 	qDot := measurement.Quaternion{
@@ -106,6 +216,10 @@ func (m *MadgwickAHRS) Update(gyro, accelero, magneto measurement.Vector3D) {
 		hy := _2q0mx*m.Quaternion.Q3 + magneto.Y*q0q0 - _2q0mz*m.Quaternion.Q1 + _2q1mx*m.Quaternion.Q2 - magneto.Y*q1q1 + magneto.Y*q2q2 + _2q2*magneto.Z*m.Quaternion.Q3 - magneto.Y*q3q3
 		_2bx := math.Sqrt(hx*hx + hy*hy)
 		_2bz := -_2q0mx*m.Quaternion.Q2 + _2q0my*m.Quaternion.Q1 + magneto.Z*q0q0 + _2q1mx*m.Quaternion.Q3 - magneto.Z*q1q1 + _2q2*magneto.Y*m.Quaternion.Q3 - magneto.Z*q2q2 + magneto.Z*q3q3
+		if m.MagneticReference != nil {
+			_2bx = math.Hypot(m.MagneticReference.X, m.MagneticReference.Y)
+			_2bz = m.MagneticReference.Z
+		}
 		_4bx := 2.0 * _2bx
 		_4bz := 2.0 * _2bz
 
@@ -130,10 +244,11 @@ func (m *MadgwickAHRS) Update(gyro, accelero, magneto measurement.Vector3D) {
 	}
 
 	// Integrate rate of change of quaternion to yield quaternion
-	m.Quaternion.Q0 += qDot.Q0 * (1.0 / m.SamplingFrequency)
-	m.Quaternion.Q1 += qDot.Q1 * (1.0 / m.SamplingFrequency)
-	m.Quaternion.Q2 += qDot.Q2 * (1.0 / m.SamplingFrequency)
-	m.Quaternion.Q3 += qDot.Q3 * (1.0 / m.SamplingFrequency)
+	dt = samplePeriod(dt, 1.0/m.SamplingFrequency)
+	m.Quaternion.Q0 += qDot.Q0 * dt
+	m.Quaternion.Q1 += qDot.Q1 * dt
+	m.Quaternion.Q2 += qDot.Q2 * dt
+	m.Quaternion.Q3 += qDot.Q3 * dt
 
 	// Normalise quaternion
 	recipNorm := FastInvSqrt64(m.Quaternion.SquareSum())
@@ -141,9 +256,8 @@ func (m *MadgwickAHRS) Update(gyro, accelero, magneto measurement.Vector3D) {
 }
 
 // UpdateIMU is used to update the quaternion if 6DOF is used
-func (m *MadgwickAHRS) UpdateIMU(gyro, accelero measurement.Vector3D) {
-	// Convert gyroscope degrees / sec to radians / sec
-	gyro.Scale(0.0174533)
+func (m *MadgwickAHRS) UpdateIMU(gyro, accelero measurement.Vector3D, dt float64) {
+	m.updateGain(gyro, accelero, samplePeriod(dt, 1.0/m.SamplingFrequency))
 
 	// Rate of change of quaternion from gyroscope
 	qDot := measurement.Quaternion{
@@ -195,10 +309,11 @@ func (m *MadgwickAHRS) UpdateIMU(gyro, accelero measurement.Vector3D) {
 	}
 
 	// Integrate rate of change of quaternion to yield quaternion
-	m.Quaternion.Q0 += qDot.Q0 * (1.0 / m.SamplingFrequency)
-	m.Quaternion.Q1 += qDot.Q1 * (1.0 / m.SamplingFrequency)
-	m.Quaternion.Q2 += qDot.Q2 * (1.0 / m.SamplingFrequency)
-	m.Quaternion.Q3 += qDot.Q3 * (1.0 / m.SamplingFrequency)
+	dt = samplePeriod(dt, 1.0/m.SamplingFrequency)
+	m.Quaternion.Q0 += qDot.Q0 * dt
+	m.Quaternion.Q1 += qDot.Q1 * dt
+	m.Quaternion.Q2 += qDot.Q2 * dt
+	m.Quaternion.Q3 += qDot.Q3 * dt
 
 	// Normalise quaternion
 	recipNorm := FastInvSqrt64(m.Quaternion.SquareSum())
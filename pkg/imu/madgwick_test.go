@@ -0,0 +1,127 @@
+package imu
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+)
+
+// stillGyro and stillAccelero are the readings of a sensor resting level
+var (
+	stillGyro     = measurement.Vector3D{X: 0.0, Y: 0.0, Z: 0.0, Frame: measurement.FrameSensor}
+	stillAccelero = measurement.Vector3D{X: 0.0, Y: 0.0, Z: standardGravity, Frame: measurement.FrameSensor}
+)
+
+// newRegisteredMadgwick creates the Madgwick filter through the registry, as the commands do
+func newRegisteredMadgwick(t *testing.T, samplingfreq float64, params map[string]float64) *MadgwickAHRS {
+	t.Helper()
+
+	f, err := NewFilter("madgwick", samplingfreq, params)
+	if err != nil {
+		t.Fatalf("unable to create filter: %s", err.Error())
+	}
+
+	return f.(*MadgwickAHRS)
+}
+
+func TestMadgwickGainSchedule(t *testing.T) {
+	const samplingfreq = 100.0
+	m := newRegisteredMadgwick(t, samplingfreq, nil)
+
+	if !m.Adaptive || m.Beta != m.BetaInit {
+		t.Fatalf("default filter does not start from the initial gain: adaptive %t, beta %f", m.Adaptive, m.Beta)
+	}
+
+	// The gain decays linearly from BetaInit, it is halfway after half of the convergence time
+	steps := int(math.Round(m.ConvergenceTime * samplingfreq))
+	previous := m.Beta
+	for i := 1; i <= steps; i++ {
+		m.Update(stillGyro, stillAccelero, measurement.Vector3D{}, 1.0/samplingfreq)
+
+		if m.Beta > previous {
+			t.Fatalf("gain increased during convergence at step %d: %f > %f", i, m.Beta, previous)
+		}
+		previous = m.Beta
+
+		if i == steps/2 {
+			want := (m.BetaInit + m.BetaDef) / 2.0
+			if math.Abs(m.Beta-want) > 1e-6 {
+				t.Errorf("gain halfway through convergence: got %f, want %f", m.Beta, want)
+			}
+		}
+	}
+
+	// At rest the schedule settles on beta after the convergence time
+	m.Update(stillGyro, stillAccelero, measurement.Vector3D{}, 1.0/samplingfreq)
+	if math.Abs(m.Beta-m.BetaDef) > 1e-9 {
+		t.Errorf("gain after %.1f sec: got %f, want %f", m.ConvergenceTime, m.Beta, m.BetaDef)
+	}
+
+	// Linear acceleration and fast rotation lower the gain below beta
+	shaken := stillAccelero
+	shaken.Scale(1.2)
+	m.Update(measurement.Vector3D{X: 3.0, Y: 0.0, Z: 0.0}, shaken, measurement.Vector3D{}, 1.0/samplingfreq)
+	if m.Beta >= m.BetaDef {
+		t.Errorf("gain while moving: got %f, want below %f", m.Beta, m.BetaDef)
+	}
+}
+
+func TestMadgwickFixedGain(t *testing.T) {
+	m := newRegisteredMadgwick(t, 100.0, map[string]float64{"beta": 2.0, "adaptive": 0.0})
+
+	for i := 0; i < 300; i++ {
+		m.Update(stillGyro, stillAccelero, measurement.Vector3D{}, 0.01)
+		if m.Beta != 2.0 {
+			t.Fatalf("fixed gain changed at step %d: %f", i, m.Beta)
+		}
+	}
+}
+
+func TestMadgwickInitializeSkipsConvergence(t *testing.T) {
+	m := newRegisteredMadgwick(t, 100.0, nil)
+	m.Initialize(measurement.Quaternion{Q0: 1.0})
+
+	m.Update(stillGyro, stillAccelero, measurement.Vector3D{}, 0.01)
+	if math.Abs(m.Beta-m.BetaDef) > 1e-9 {
+		t.Errorf("gain of the aligned filter: got %f, want %f", m.Beta, m.BetaDef)
+	}
+}
+
+func TestMadgwickInvalidSchedule(t *testing.T) {
+	for _, name := range []string{"beta_init", "convergence", "acc_tolerance", "rate_scale"} {
+		for _, value := range []float64{0.0, -1.0, math.NaN()} {
+			_, err := NewFilter("madgwick", 100.0, map[string]float64{name: value})
+			if err == nil {
+				t.Errorf("%s=%g: filter created, want an error", name, value)
+			}
+		}
+
+		// The fixed gain filter does not use the schedule
+		_, err := NewFilter("madgwick", 100.0, map[string]float64{name: 0.0, "adaptive": 0.0})
+		if err != nil {
+			t.Errorf("%s=0 with fixed gain: unexpected error: %s", name, err.Error())
+		}
+	}
+}
+
+// testField is the magnetic field used by the attitude tests, in Gauss in the NWU frame
+var testField = measurement.Vector3D{X: 0.2, Y: 0.0, Z: -0.45, Frame: measurement.FrameNWU}
+
+// getTestAttitude returns the tilted and turned orientation of the sensor in the attitude tests
+func getTestAttitude() measurement.Quaternion {
+	e := measurement.EulerAngles{Roll: 0.35, Pitch: -0.2, Yaw: 1.1, Frame: measurement.FrameNWU}
+	return e.GetAsQuaternion()
+}
+
+// getStillReadings returns the accelerometer and magnetometer readings of a sensor resting in the given orientation
+func getStillReadings(q measurement.Quaternion) (measurement.Vector3D, measurement.Vector3D) {
+	up := measurement.Vector3D{X: 0.0, Y: 0.0, Z: standardGravity}
+
+	accelero := q.Conjugate().Rotate(up)
+	accelero.Frame = measurement.FrameSensor
+	magneto := q.Conjugate().Rotate(testField)
+	magneto.Frame = measurement.FrameSensor
+
+	return accelero, magneto
+}
@@ -0,0 +1,192 @@
+package imu
+
+import (
+	"math"
+
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+)
+
+// MahonyAHRS is the PI-controller complementary filter of Mahony et al., estimating the orientation
+// relative to the NWU frame of magnetic north and gravity. The integral term compensates the gyroscope bias.
+type MahonyAHRS struct {
+	SamplingFrequency float64
+	Quaternion        measurement.Quaternion
+	Kp                float64
+	Ki                float64
+	IntegralFB        measurement.Vector3D
+}
+
+func NewMahonyAHRS(samplingfreq, kp, ki float64) *MahonyAHRS {
+	m := MahonyAHRS{
+		SamplingFrequency: samplingfreq,
+		Kp:                kp,
+		Ki:                ki,
+	}
+	m.Reset()
+
+	return &m
+}
+
+func init() {
+	err := RegisterFilter("mahony", func(samplingfreq float64, params map[string]float64) (OrientationFilter, error) {
+		p, err := getParams(map[string]float64{"kp": 0.5, "ki": 0.05}, params)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewMahonyAHRS(samplingfreq, p["kp"], p["ki"]), nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// GetQuaternion returns the current orientation estimate
+func (m *MahonyAHRS) GetQuaternion() measurement.Quaternion {
+	return m.Quaternion
+}
+
+// GetGyroBias returns the gyroscope bias in radians / sec estimated by the integral term
+func (m *MahonyAHRS) GetGyroBias() measurement.Vector3D {
+	bias := m.IntegralFB
+	bias.Scale(-1.0)
+
+	return bias
+}
+
+// Reset sets the filter back to identity orientation and clears the integral term
+func (m *MahonyAHRS) Reset() {
+	m.Quaternion = measurement.Quaternion{Q0: 1.0, Q1: 0.0, Q2: 0.0, Q3: 0.0, Frame: measurement.FrameNWU}
+	m.IntegralFB = measurement.Vector3D{X: 0.0, Y: 0.0, Z: 0.0, Frame: measurement.FrameSensor}
+}
+
+// Initialize resets the filter and starts it from the given orientation
+func (m *MahonyAHRS) Initialize(q measurement.Quaternion) {
+	m.Reset()
+	m.Quaternion = q
+	m.Quaternion.Frame = measurement.FrameNWU
+}
+
+// ApplyPrewarm starts the filter from the coarse alignment of a stationary subset of the data
+func (m *MahonyAHRS) ApplyPrewarm(gyro, accelero, magneto []measurement.Vector3D, dt []float64) {
+	applyPrewarm(m, gyro, accelero, magneto, dt)
+}
+
+// Update is used to update the quaternion if 9DOF is used.
+// Gyroscope readings are expected in radians / sec, dt is the time elapsed since the previous update in seconds.
+func (m *MahonyAHRS) Update(gyro, accelero, magneto measurement.Vector3D, dt float64) {
+	if magneto.IsEmpty() {
+		m.UpdateIMU(gyro, accelero, dt)
+		return
+	}
+
+	dt = samplePeriod(dt, 1.0/m.SamplingFrequency)
+
+	// Compute feedback only if accelerometer measurement valid (avoids NaN in accelerometer normalisation)
+	if !accelero.IsEmpty() {
+		// Normalise accelerometer measurement
+		recipNorm := FastInvSqrt64(accelero.SquareSum())
+		accelero.Scale(recipNorm)
+
+		// Normalise magnetometer measurement
+		recipNorm = FastInvSqrt64(magneto.SquareSum())
+		magneto.Scale(recipNorm)
+
+		// Auxiliary variables to avoid repeated arithmetic
+		q0q0 := m.Quaternion.Q0 * m.Quaternion.Q0
+		q0q1 := m.Quaternion.Q0 * m.Quaternion.Q1
+		q0q2 := m.Quaternion.Q0 * m.Quaternion.Q2
+		q0q3 := m.Quaternion.Q0 * m.Quaternion.Q3
+		q1q1 := m.Quaternion.Q1 * m.Quaternion.Q1
+		q1q2 := m.Quaternion.Q1 * m.Quaternion.Q2
+		q1q3 := m.Quaternion.Q1 * m.Quaternion.Q3
+		q2q2 := m.Quaternion.Q2 * m.Quaternion.Q2
+		q2q3 := m.Quaternion.Q2 * m.Quaternion.Q3
+		q3q3 := m.Quaternion.Q3 * m.Quaternion.Q3
+
+		// Reference direction of Earth's magnetic field
+		hx := 2.0 * (magneto.X*(0.5-q2q2-q3q3) + magneto.Y*(q1q2-q0q3) + magneto.Z*(q1q3+q0q2))
+		hy := 2.0 * (magneto.X*(q1q2+q0q3) + magneto.Y*(0.5-q1q1-q3q3) + magneto.Z*(q2q3-q0q1))
+		bx := math.Sqrt(hx*hx + hy*hy)
+		bz := 2.0 * (magneto.X*(q1q3-q0q2) + magneto.Y*(q2q3+q0q1) + magneto.Z*(0.5-q1q1-q2q2))
+
+		// Estimated direction of gravity and magnetic field
+		halfv := measurement.Vector3D{X: q1q3 - q0q2, Y: q0q1 + q2q3, Z: q0q0 - 0.5 + q3q3}
+		halfw := measurement.Vector3D{
+			X: bx*(0.5-q2q2-q3q3) + bz*(q1q3-q0q2),
+			Y: bx*(q1q2-q0q3) + bz*(q0q1+q2q3),
+			Z: bx*(q0q2+q1q3) + bz*(0.5-q1q1-q2q2),
+		}
+
+		// Error is sum of cross product between estimated direction and measured direction of field vectors
+		halfea := accelero.Cross(halfv)
+		halfem := magneto.Cross(halfw)
+		halfe := measurement.Vector3D{X: halfea.X + halfem.X, Y: halfea.Y + halfem.Y, Z: halfea.Z + halfem.Z}
+
+		gyro = m.applyFeedback(gyro, halfe, dt)
+	}
+
+	m.integrate(gyro, dt)
+}
+
+// UpdateIMU is used to update the quaternion if 6DOF is used
+func (m *MahonyAHRS) UpdateIMU(gyro, accelero measurement.Vector3D, dt float64) {
+	dt = samplePeriod(dt, 1.0/m.SamplingFrequency)
+
+	// Compute feedback only if accelerometer measurement valid (avoids NaN in accelerometer normalisation)
+	if !accelero.IsEmpty() {
+		// Normalise accelerometer measurement
+		recipNorm := FastInvSqrt64(accelero.SquareSum())
+		accelero.Scale(recipNorm)
+
+		// Estimated direction of gravity
+		halfv := measurement.Vector3D{
+			X: m.Quaternion.Q1*m.Quaternion.Q3 - m.Quaternion.Q0*m.Quaternion.Q2,
+			Y: m.Quaternion.Q0*m.Quaternion.Q1 + m.Quaternion.Q2*m.Quaternion.Q3,
+			Z: m.Quaternion.Q0*m.Quaternion.Q0 - 0.5 + m.Quaternion.Q3*m.Quaternion.Q3,
+		}
+
+		// Error is cross product between estimated and measured direction of gravity
+		gyro = m.applyFeedback(gyro, accelero.Cross(halfv), dt)
+	}
+
+	m.integrate(gyro, dt)
+}
+
+// applyFeedback returns the gyroscope reading corrected by the proportional and integral feedback of the error
+func (m *MahonyAHRS) applyFeedback(gyro, halfe measurement.Vector3D, dt float64) measurement.Vector3D {
+	if m.Ki > 0.0 {
+		// Integral error scaled by Ki, applied as the gyroscope bias correction
+		m.IntegralFB.X += 2.0 * m.Ki * halfe.X * dt
+		m.IntegralFB.Y += 2.0 * m.Ki * halfe.Y * dt
+		m.IntegralFB.Z += 2.0 * m.Ki * halfe.Z * dt
+		gyro.X += m.IntegralFB.X
+		gyro.Y += m.IntegralFB.Y
+		gyro.Z += m.IntegralFB.Z
+	} else {
+		// Prevent integral windup
+		m.IntegralFB = measurement.Vector3D{X: 0.0, Y: 0.0, Z: 0.0, Frame: measurement.FrameSensor}
+	}
+
+	// Apply proportional feedback
+	gyro.X += 2.0 * m.Kp * halfe.X
+	gyro.Y += 2.0 * m.Kp * halfe.Y
+	gyro.Z += 2.0 * m.Kp * halfe.Z
+
+	return gyro
+}
+
+// integrate applies the rate of change of quaternion and normalises the result
+func (m *MahonyAHRS) integrate(gyro measurement.Vector3D, dt float64) {
+	gyro.Scale(0.5 * dt)
+
+	q := m.Quaternion
+	m.Quaternion.Q0 += -q.Q1*gyro.X - q.Q2*gyro.Y - q.Q3*gyro.Z
+	m.Quaternion.Q1 += q.Q0*gyro.X + q.Q2*gyro.Z - q.Q3*gyro.Y
+	m.Quaternion.Q2 += q.Q0*gyro.Y - q.Q1*gyro.Z + q.Q3*gyro.X
+	m.Quaternion.Q3 += q.Q0*gyro.Z + q.Q1*gyro.Y - q.Q2*gyro.X
+
+	// Normalise quaternion
+	recipNorm := FastInvSqrt64(m.Quaternion.SquareSum())
+	m.Quaternion.Scale(recipNorm)
+}
@@ -0,0 +1,62 @@
+package imu
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+)
+
+func TestMahonyGyroBias(t *testing.T) {
+	const samplingfreq = 100.0
+	bias := measurement.Vector3D{X: 0.02, Y: -0.01, Z: 0.015, Frame: measurement.FrameSensor}
+	want := getTestAttitude()
+	accelero, magneto := getStillReadings(want)
+
+	f, err := NewFilter("mahony", samplingfreq, map[string]float64{"kp": 1.0, "ki": 0.1})
+	if err != nil {
+		t.Fatalf("unable to create filter: %s", err.Error())
+	}
+	m := f.(*MahonyAHRS)
+
+	// The still sensor reads only the bias, the integral term takes it over from the proportional one
+	for i := 0; i < 200*samplingfreq; i++ {
+		m.Update(bias, accelero, magneto, 1.0/samplingfreq)
+	}
+
+	got := m.GetGyroBias()
+	if math.Abs(got.X-bias.X) > 1e-4 || math.Abs(got.Y-bias.Y) > 1e-4 || math.Abs(got.Z-bias.Z) > 1e-4 {
+		t.Errorf("gyro bias: got %v, want %v", got, bias)
+	}
+
+	// The fast inverse square root leaves the estimate slightly off unit length
+	q := m.GetQuaternion()
+	q.Normalize()
+	if d := q.AngularDistance(want); d > 1.0*math.Pi/180.0 {
+		t.Errorf("orientation: got %v, %.3f degree from %v", q.GetAsEuler(), d*180.0/math.Pi, want.GetAsEuler())
+	}
+}
+
+func TestMahonyWithoutIntegralTerm(t *testing.T) {
+	const samplingfreq = 100.0
+	bias := measurement.Vector3D{X: 0.02, Y: -0.01, Z: 0.015, Frame: measurement.FrameSensor}
+	accelero, _ := getStillReadings(getTestAttitude())
+
+	m := NewMahonyAHRS(samplingfreq, 1.0, 0.0)
+	for i := 0; i < 60*samplingfreq; i++ {
+		m.UpdateIMU(bias, accelero, 1.0/samplingfreq)
+	}
+
+	// The bias is not estimated, the proportional term leaves a steady tilt error against it
+	if !m.GetGyroBias().IsEmpty() {
+		t.Errorf("gyro bias without integral term: got %v", m.GetGyroBias())
+	}
+
+	q := m.GetQuaternion()
+	q.Normalize()
+	expected := q.Conjugate().Rotate(measurement.Vector3D{Z: 1.0})
+	accelero.Scale(1.0 / accelero.Norm())
+	if tilt := math.Acos(math.Min(expected.Dot(accelero), 1.0)); tilt < 0.1*math.Pi/180.0 {
+		t.Errorf("tilt error: got %.3f degree, want a steady error", tilt*180.0/math.Pi)
+	}
+}
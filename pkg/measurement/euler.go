@@ -0,0 +1,187 @@
+package measurement
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ErrGimbalLock is returned when the first and third Euler angle can not be told apart, the third one is set to zero then
+var ErrGimbalLock = errors.New("gimbal lock: first and third Euler angle are not independent")
+
+// gimbalLockTolerance is the distance in radians of the middle angle from its singularity treated as gimbal lock
+const gimbalLockTolerance = 1e-7
+
+// Axis is a coordinate axis of an elementary rotation
+type Axis int
+
+const (
+	AxisX Axis = iota
+	AxisY
+	AxisZ
+)
+
+// EulerSequence is an Euler angle convention: the axes of the elementary rotations in the order they are applied.
+// Intrinsic rotations are about the axes of the rotating frame, extrinsic ones about the fixed frame.
+type EulerSequence struct {
+	Axes      [3]Axis
+	Intrinsic bool
+}
+
+// SequenceAerospace is the intrinsic ZYX (yaw, pitch, roll) sequence used by the XSens Euler output
+var SequenceAerospace = EulerSequence{Axes: [3]Axis{AxisZ, AxisY, AxisX}, Intrinsic: true}
+
+// ParseEulerSequence parses sequences like "ZYX" or "zxz", upper case is intrinsic and lower case is extrinsic.
+// All 6 Tait-Bryan and 6 proper Euler orders are accepted.
+func ParseEulerSequence(name string) (EulerSequence, error) {
+	result := EulerSequence{}
+
+	if len(name) != 3 {
+		return result, fmt.Errorf("invalid Euler sequence: %q", name)
+	}
+
+	switch name {
+	case strings.ToUpper(name):
+		result.Intrinsic = true
+	case strings.ToLower(name):
+		result.Intrinsic = false
+	default:
+		return result, fmt.Errorf("invalid Euler sequence, mixed intrinsic and extrinsic axes: %q", name)
+	}
+
+	for i, c := range strings.ToUpper(name) {
+		switch c {
+		case 'X':
+			result.Axes[i] = AxisX
+		case 'Y':
+			result.Axes[i] = AxisY
+		case 'Z':
+			result.Axes[i] = AxisZ
+		default:
+			return result, fmt.Errorf("invalid Euler sequence axis: %q", c)
+		}
+	}
+
+	if result.Axes[0] == result.Axes[1] || result.Axes[1] == result.Axes[2] {
+		return result, fmt.Errorf("invalid Euler sequence, consecutive rotations about the same axis: %q", name)
+	}
+
+	return result, nil
+}
+
+func (s EulerSequence) String() string {
+	name := ""
+	for _, a := range s.Axes {
+		name += string("XYZ"[a])
+	}
+
+	if !s.Intrinsic {
+		return strings.ToLower(name)
+	}
+
+	return name
+}
+
+// IsProper checks if the sequence is a proper Euler sequence (first and last axis equal) instead of Tait-Bryan
+func (s EulerSequence) IsProper() bool {
+	return s.Axes[0] == s.Axes[2]
+}
+
+// getAxisRotation returns the quaternion of an elementary rotation
+func getAxisRotation(a Axis, angle float64) Quaternion {
+	result := Quaternion{Q0: math.Cos(angle / 2.0)}
+
+	switch a {
+	case AxisX:
+		result.Q1 = math.Sin(angle / 2.0)
+	case AxisY:
+		result.Q2 = math.Sin(angle / 2.0)
+	case AxisZ:
+		result.Q3 = math.Sin(angle / 2.0)
+	}
+
+	return result
+}
+
+// NewQuaternionFromEulerSequence returns the quaternion of the given angles in radians, in the order of the sequence
+func NewQuaternionFromEulerSequence(angles [3]float64, seq EulerSequence) Quaternion {
+	result := Quaternion{Q0: 1.0, Q1: 0.0, Q2: 0.0, Q3: 0.0}
+
+	for i := 0; i < 3; i++ {
+		r := getAxisRotation(seq.Axes[i], angles[i])
+		if seq.Intrinsic {
+			result = result.Multiply(r)
+		} else {
+			result = r.Multiply(result)
+		}
+	}
+
+	return result
+}
+
+// GetAsEulerSequence returns the Euler angles of a (unit) quaternion in radians, in the order of the sequence.
+// In gimbal lock the third angle is set to zero and ErrGimbalLock is returned alongside the angles.
+func (q Quaternion) GetAsEulerSequence(seq EulerSequence) ([3]float64, error) {
+	// Bernardes & Viollet, "Quaternion to Euler angles conversion: A direct, general and computationally efficient method"
+	angles := [3]float64{}
+	axes := seq.Axes
+	first, third := 0, 2
+	if seq.Intrinsic {
+		axes = [3]Axis{seq.Axes[2], seq.Axes[1], seq.Axes[0]}
+		first, third = 2, 0
+	}
+
+	i, j, k := int(axes[0]), int(axes[1]), int(axes[2])
+	proper := i == k
+	if proper {
+		k = 3 - i - j
+	}
+	sign := float64((i - j) * (j - k) * (k - i) / 2)
+
+	v := [3]float64{q.Q1, q.Q2, q.Q3}
+	var a, b, c, d float64
+	if proper {
+		a, b, c, d = q.Q0, v[i], v[j], v[k]*sign
+	} else {
+		a, b, c, d = q.Q0-v[j], v[i]+v[k]*sign, v[j]+q.Q0, v[k]*sign-v[i]
+	}
+
+	angles[1] = 2.0 * math.Atan2(math.Hypot(c, d), math.Hypot(a, b))
+	halfSum := math.Atan2(b, a)
+	halfDiff := math.Atan2(d, c)
+
+	var err error
+	switch {
+	case math.Abs(angles[1]) <= gimbalLockTolerance:
+		err = ErrGimbalLock
+		angles[2] = 0.0
+		angles[0] = 2.0 * halfSum
+	case math.Abs(angles[1]-math.Pi) <= gimbalLockTolerance:
+		err = ErrGimbalLock
+		angles[2] = 0.0
+		angles[0] = 2.0 * halfDiff
+		if !seq.Intrinsic {
+			angles[0] = -angles[0]
+		}
+	default:
+		angles[first] = halfSum - halfDiff
+		angles[third] = halfSum + halfDiff
+	}
+
+	if !proper {
+		angles[third] *= sign
+		angles[1] -= math.Pi / 2.0
+	}
+
+	for idx := range angles {
+		angles[idx] = wrapAngle(angles[idx])
+	}
+
+	return angles, err
+}
+
+// wrapAngle maps an angle in radians to [-pi, pi]
+func wrapAngle(angle float64) float64 {
+	return math.Atan2(math.Sin(angle), math.Cos(angle))
+}
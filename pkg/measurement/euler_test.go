@@ -0,0 +1,125 @@
+package measurement
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+// eulerSequences are the 6 Tait-Bryan and 6 proper Euler orders
+var eulerSequences = []string{"XYZ", "XZY", "YXZ", "YZX", "ZXY", "ZYX", "XYX", "XZX", "YXY", "YZY", "ZXZ", "ZYZ"}
+
+// getSequences returns the intrinsic and extrinsic variant of every order
+func getSequences(t *testing.T) []EulerSequence {
+	t.Helper()
+
+	result := make([]EulerSequence, 0, 2*len(eulerSequences))
+	for _, name := range eulerSequences {
+		for _, variant := range []string{name, strings.ToLower(name)} {
+			seq, err := ParseEulerSequence(variant)
+			if err != nil {
+				t.Fatalf("unable to parse sequence %s: %s", variant, err.Error())
+			}
+			result = append(result, seq)
+		}
+	}
+
+	return result
+}
+
+func TestEulerSequenceRoundTrip(t *testing.T) {
+	for _, seq := range getSequences(t) {
+		// The middle angle of proper sequences is in [0, pi], of Tait-Bryan ones in [-pi/2, pi/2]
+		middle := []float64{-1.2, -0.3, 0.4, 1.5}
+		if seq.IsProper() {
+			middle = []float64{0.2, 1.0, 2.0, 3.0}
+		}
+
+		for _, m := range middle {
+			for _, outer := range [][2]float64{{0.3, -0.7}, {-2.9, 2.5}, {1.6, 0.0}} {
+				angles := [3]float64{outer[0], m, outer[1]}
+				q := NewQuaternionFromEulerSequence(angles, seq)
+
+				got, err := q.GetAsEulerSequence(seq)
+				if err != nil {
+					t.Errorf("%s %v: unexpected error: %s", seq, angles, err.Error())
+					continue
+				}
+
+				for idx := range angles {
+					if math.Abs(wrapAngle(got[idx]-angles[idx])) > testTolerance {
+						t.Errorf("%s: got %v, want %v", seq, got, angles)
+						break
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestEulerSequenceGimbalLock(t *testing.T) {
+	for _, seq := range getSequences(t) {
+		singular := []float64{-math.Pi / 2.0, math.Pi / 2.0}
+		if seq.IsProper() {
+			singular = []float64{0.0, math.Pi}
+		}
+
+		for _, m := range singular {
+			angles := [3]float64{0.4, m, -0.9}
+			q := NewQuaternionFromEulerSequence(angles, seq)
+
+			got, err := q.GetAsEulerSequence(seq)
+			if !errors.Is(err, ErrGimbalLock) {
+				t.Errorf("%s %v: got error %v, want gimbal lock", seq, angles, err)
+			}
+
+			if got[2] != 0.0 {
+				t.Errorf("%s %v: third angle is %f in gimbal lock, want 0", seq, angles, got[2])
+			}
+
+			// The angles are not unique, but they still describe the same rotation
+			assertRotation(t, seq.String()+" gimbal lock", NewQuaternionFromEulerSequence(got, seq), q)
+		}
+	}
+}
+
+func TestEulerAerospace(t *testing.T) {
+	e := EulerAngles{Roll: 0.3, Pitch: -0.4, Yaw: 2.0}
+	q := e.GetAsQuaternion()
+
+	assertRotation(t, "aerospace", NewQuaternionFromEulerSequence([3]float64{e.Yaw, e.Pitch, e.Roll}, SequenceAerospace), q)
+
+	got := q.GetAsEuler()
+	if math.Abs(got.Roll-e.Roll) > testTolerance || math.Abs(got.Pitch-e.Pitch) > testTolerance ||
+		math.Abs(got.Yaw-e.Yaw) > testTolerance {
+		t.Errorf("aerospace round trip: got %+v, want %+v", got, e)
+	}
+}
+
+func TestParseEulerSequence(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"ZYX", true},
+		{"zxz", true},
+		{"ZyX", false},
+		{"ZZX", false},
+		{"XYY", false},
+		{"XYW", false},
+		{"XY", false},
+	}
+
+	for _, tt := range tests {
+		seq, err := ParseEulerSequence(tt.name)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: got error %v, want valid %t", tt.name, err, tt.valid)
+			continue
+		}
+
+		if tt.valid && seq.String() != tt.name {
+			t.Errorf("%s: parsed as %s", tt.name, seq)
+		}
+	}
+}
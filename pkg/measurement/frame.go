@@ -0,0 +1,153 @@
+package measurement
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Frame is the coordinate frame a vector or an orientation is expressed in
+type Frame int
+
+const (
+	// FrameUnknown is used when the frame is not known, conversions from and to it are not possible
+	FrameUnknown Frame = iota
+	// FrameSensor is the sensor fixed frame of the raw readings
+	FrameSensor
+	// FrameENU is the East-North-Up navigation frame
+	FrameENU
+	// FrameNED is the North-East-Down navigation frame
+	FrameNED
+	// FrameNWU is the North-West-Up navigation frame
+	FrameNWU
+)
+
+// ErrUnknownFrame is returned when converting from or to FrameUnknown, the caller has to state the frame first
+var ErrUnknownFrame = errors.New("coordinate frame is unknown")
+
+// frameToENU holds the rotation taking coordinates of a navigation frame to ENU
+var frameToENU = map[Frame]Quaternion{
+	FrameENU: {Q0: 1.0, Q1: 0.0, Q2: 0.0, Q3: 0.0, Frame: FrameENU},
+	FrameNED: {Q0: 0.0, Q1: math.Sqrt2 / 2.0, Q2: math.Sqrt2 / 2.0, Q3: 0.0, Frame: FrameENU},
+	FrameNWU: {Q0: math.Sqrt2 / 2.0, Q1: 0.0, Q2: 0.0, Q3: math.Sqrt2 / 2.0, Frame: FrameENU},
+}
+
+// ParseFrame returns the frame of the given name (e.g. "ENU" from the log header)
+func ParseFrame(name string) (Frame, error) {
+	switch strings.ToUpper(strings.TrimSpace(name)) {
+	case "ENU":
+		return FrameENU, nil
+	case "NED":
+		return FrameNED, nil
+	case "NWU":
+		return FrameNWU, nil
+	case "SENSOR":
+		return FrameSensor, nil
+	}
+
+	return FrameUnknown, fmt.Errorf("unknown coordinate frame: %q", name)
+}
+
+func (f Frame) String() string {
+	switch f {
+	case FrameSensor:
+		return "Sensor"
+	case FrameENU:
+		return "ENU"
+	case FrameNED:
+		return "NED"
+	case FrameNWU:
+		return "NWU"
+	}
+
+	return "Unknown"
+}
+
+// IsNavigation checks if the frame is an earth fixed navigation frame
+func (f Frame) IsNavigation() bool {
+	_, ok := frameToENU[f]
+	return ok
+}
+
+// GetFrameRotation returns the rotation taking coordinates from one navigation frame to another, ErrUnknownFrame
+// if either of them is FrameUnknown
+func GetFrameRotation(from, to Frame) (Quaternion, error) {
+	if from == FrameUnknown || to == FrameUnknown {
+		return Quaternion{}, ErrUnknownFrame
+	}
+
+	fromENU, ok := frameToENU[from]
+	if !ok {
+		return Quaternion{}, fmt.Errorf("unable to convert from %s frame", from)
+	}
+
+	toENU, ok := frameToENU[to]
+	if !ok {
+		return Quaternion{}, fmt.Errorf("unable to convert to %s frame", to)
+	}
+
+	result := toENU.Conjugate().Multiply(fromENU)
+	result.Frame = to
+
+	return result, nil
+}
+
+// InFrame returns the vector expressed in the given navigation frame
+func (m Vector3D) InFrame(f Frame) (Vector3D, error) {
+	if m.Frame == f {
+		return m, nil
+	}
+
+	r, err := GetFrameRotation(m.Frame, f)
+	if err != nil {
+		return m, err
+	}
+
+	return r.Rotate(m), nil
+}
+
+// InFrame returns the orientation relative to the given navigation frame
+func (q Quaternion) InFrame(f Frame) (Quaternion, error) {
+	if q.Frame == f {
+		return q, nil
+	}
+
+	r, err := GetFrameRotation(q.Frame, f)
+	if err != nil {
+		return q, err
+	}
+
+	result := r.Multiply(q)
+	result.Frame = f
+
+	return result, nil
+}
+
+// InFrame returns the orientation relative to the given navigation frame
+func (e EulerAngles) InFrame(f Frame) (EulerAngles, error) {
+	if e.Frame == f {
+		return e, nil
+	}
+
+	q, err := e.GetAsQuaternion().InFrame(f)
+	if err != nil {
+		return e, err
+	}
+
+	return q.GetAsEuler(), nil
+}
+
+// InFrame returns the orientation relative to the given navigation frame
+func (r RotationMatrix) InFrame(f Frame) (RotationMatrix, error) {
+	if r.Frame == f {
+		return r, nil
+	}
+
+	c, err := GetFrameRotation(r.Frame, f)
+	if err != nil {
+		return r, err
+	}
+
+	return c.GetAsMatrix().Multiply(r), nil
+}
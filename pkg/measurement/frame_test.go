@@ -0,0 +1,61 @@
+package measurement
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestInFrame(t *testing.T) {
+	// North expressed in each navigation frame
+	north := map[Frame]Vector3D{
+		FrameENU: {Y: 1.0, Frame: FrameENU},
+		FrameNED: {X: 1.0, Frame: FrameNED},
+		FrameNWU: {X: 1.0, Frame: FrameNWU},
+	}
+
+	for from, v := range north {
+		for to, want := range north {
+			got, err := v.InFrame(to)
+			if err != nil {
+				t.Errorf("%s to %s: unexpected error: %s", from, to, err.Error())
+				continue
+			}
+			assertVector(t, from.String()+" to "+to.String(), got, want)
+		}
+	}
+
+	// The identity orientation in ENU points the sensor X axis east, in NWU as well
+	east := Quaternion{Q0: 1.0, Frame: FrameENU}
+	nwu, err := east.InFrame(FrameNWU)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	assertVector(t, "east", nwu.Rotate(Vector3D{X: 1.0}), Vector3D{Y: -1.0})
+
+	azimuth, err := east.GetAzimuth()
+	if err != nil || math.Abs(azimuth-math.Pi/2.0) > testTolerance {
+		t.Errorf("azimuth: got %f, %v, want %f", azimuth, err, math.Pi/2.0)
+	}
+}
+
+func TestUnknownFrame(t *testing.T) {
+	q := Quaternion{Q0: 1.0}
+
+	if _, err := q.InFrame(FrameNWU); !errors.Is(err, ErrUnknownFrame) {
+		t.Errorf("orientation: got error %v, want %v", err, ErrUnknownFrame)
+	}
+
+	if _, err := (Vector3D{X: 1.0, Frame: FrameNED}).InFrame(FrameUnknown); !errors.Is(err, ErrUnknownFrame) {
+		t.Errorf("vector: got error %v, want %v", err, ErrUnknownFrame)
+	}
+
+	// The frame is not assumed, the heading of an orientation of unknown frame is not defined
+	if _, err := q.GetAzimuth(); !errors.Is(err, ErrUnknownFrame) {
+		t.Errorf("azimuth: got error %v, want %v", err, ErrUnknownFrame)
+	}
+
+	if _, err := (Vector3D{X: 20.0, Z: -40.0}).GetHeading(q); !errors.Is(err, ErrUnknownFrame) {
+		t.Errorf("heading: got error %v, want %v", err, ErrUnknownFrame)
+	}
+}
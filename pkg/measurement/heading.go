@@ -0,0 +1,42 @@
+package measurement
+
+import "math"
+
+// wrapAzimuth returns the azimuth in [0, 2*pi)
+func wrapAzimuth(azimuth float64) float64 {
+	result := math.Mod(azimuth, 2.0*math.Pi)
+	if result < 0.0 {
+		result += 2.0 * math.Pi
+	}
+
+	return result
+}
+
+// GetHeading returns the tilt compensated magnetic heading of the sensor: the azimuth of its X axis in radians,
+// clockwise from magnetic north in [0, 2*pi). Only roll and pitch of the orientation are used to level the
+// magnetometer reading, the yaw comes from the reading itself. ErrUnknownFrame is returned for an orientation of
+// unknown frame.
+func (m Vector3D) GetHeading(o Quaternion) (float64, error) {
+	nwu, err := o.InFrame(FrameNWU)
+	if err != nil {
+		return 0.0, err
+	}
+
+	e := nwu.GetAsEuler()
+	tilt := EulerAngles{Roll: e.Roll, Pitch: e.Pitch, Yaw: 0.0, Frame: FrameNWU}
+	level := tilt.GetAsQuaternion().Rotate(m)
+
+	// West is +Y in NWU, the azimuth grows towards east
+	return wrapAzimuth(math.Atan2(level.Y, level.X)), nil
+}
+
+// GetAzimuth returns the azimuth of the X axis of the orientation in radians, clockwise from north in [0, 2*pi).
+// ErrUnknownFrame is returned for an orientation of unknown frame.
+func (q Quaternion) GetAzimuth() (float64, error) {
+	nwu, err := q.InFrame(FrameNWU)
+	if err != nil {
+		return 0.0, err
+	}
+
+	return wrapAzimuth(-nwu.GetAsEuler().Yaw), nil
+}
@@ -0,0 +1,166 @@
+package measurement
+
+import (
+	"math"
+)
+
+// RotationMatrix is a direction cosine matrix, M[i][j] is the element in row i and column j.
+// Like Quaternion, it rotates from the sensor frame to the frame it carries.
+type RotationMatrix struct {
+	M     [3][3]float64
+	Frame Frame
+}
+
+// NewIdentityMatrix returns the rotation matrix of no rotation
+func NewIdentityMatrix() RotationMatrix {
+	return RotationMatrix{M: [3][3]float64{{1.0, 0.0, 0.0}, {0.0, 1.0, 0.0}, {0.0, 0.0, 1.0}}}
+}
+
+// GetAsMatrix returns the rotation matrix of a (unit) quaternion
+func (q Quaternion) GetAsMatrix() RotationMatrix {
+	q0q0, q1q1, q2q2, q3q3 := q.Q0*q.Q0, q.Q1*q.Q1, q.Q2*q.Q2, q.Q3*q.Q3
+	q0q1, q0q2, q0q3 := q.Q0*q.Q1, q.Q0*q.Q2, q.Q0*q.Q3
+	q1q2, q1q3, q2q3 := q.Q1*q.Q2, q.Q1*q.Q3, q.Q2*q.Q3
+
+	return RotationMatrix{
+		M: [3][3]float64{
+			{q0q0 + q1q1 - q2q2 - q3q3, 2.0 * (q1q2 - q0q3), 2.0 * (q1q3 + q0q2)},
+			{2.0 * (q1q2 + q0q3), q0q0 - q1q1 + q2q2 - q3q3, 2.0 * (q2q3 - q0q1)},
+			{2.0 * (q1q3 - q0q2), 2.0 * (q2q3 + q0q1), q0q0 - q1q1 - q2q2 + q3q3},
+		},
+		Frame: q.Frame,
+	}
+}
+
+// GetAsMatrix returns the rotation matrix of the roll-pitch-yaw rotation
+func (e EulerAngles) GetAsMatrix() RotationMatrix {
+	cr, sr := math.Cos(e.Roll), math.Sin(e.Roll)
+	cp, sp := math.Cos(e.Pitch), math.Sin(e.Pitch)
+	cy, sy := math.Cos(e.Yaw), math.Sin(e.Yaw)
+
+	return RotationMatrix{
+		M: [3][3]float64{
+			{cp * cy, sr*sp*cy - cr*sy, cr*sp*cy + sr*sy},
+			{cp * sy, sr*sp*sy + cr*cy, cr*sp*sy - sr*cy},
+			{-sp, sr * cp, cr * cp},
+		},
+		Frame: e.Frame,
+	}
+}
+
+// GetAsQuaternion returns the unit quaternion of the rotation matrix
+func (r RotationMatrix) GetAsQuaternion() Quaternion {
+	m := r.M
+	trace := m[0][0] + m[1][1] + m[2][2]
+	result := Quaternion{Frame: r.Frame}
+
+	// Shepperd's method, dividing by the largest component keeps the conversion stable
+	switch {
+	case trace > m[0][0] && trace > m[1][1] && trace > m[2][2]:
+		s := 2.0 * math.Sqrt(1.0+trace)
+		result.Q0 = 0.25 * s
+		result.Q1 = (m[2][1] - m[1][2]) / s
+		result.Q2 = (m[0][2] - m[2][0]) / s
+		result.Q3 = (m[1][0] - m[0][1]) / s
+	case m[0][0] > m[1][1] && m[0][0] > m[2][2]:
+		s := 2.0 * math.Sqrt(1.0+m[0][0]-m[1][1]-m[2][2])
+		result.Q0 = (m[2][1] - m[1][2]) / s
+		result.Q1 = 0.25 * s
+		result.Q2 = (m[0][1] + m[1][0]) / s
+		result.Q3 = (m[0][2] + m[2][0]) / s
+	case m[1][1] > m[2][2]:
+		s := 2.0 * math.Sqrt(1.0+m[1][1]-m[0][0]-m[2][2])
+		result.Q0 = (m[0][2] - m[2][0]) / s
+		result.Q1 = (m[0][1] + m[1][0]) / s
+		result.Q2 = 0.25 * s
+		result.Q3 = (m[1][2] + m[2][1]) / s
+	default:
+		s := 2.0 * math.Sqrt(1.0+m[2][2]-m[0][0]-m[1][1])
+		result.Q0 = (m[1][0] - m[0][1]) / s
+		result.Q1 = (m[0][2] + m[2][0]) / s
+		result.Q2 = (m[1][2] + m[2][1]) / s
+		result.Q3 = 0.25 * s
+	}
+
+	// Keep the scalar part positive like the XSens quaternion output
+	if result.Q0 < 0.0 {
+		result.Scale(-1.0)
+	}
+
+	return result
+}
+
+// GetAsEuler returns the roll-pitch-yaw angles of the rotation matrix
+func (r RotationMatrix) GetAsEuler() EulerAngles {
+	return EulerAngles{
+		Roll:  math.Atan2(r.M[2][1], r.M[2][2]),
+		Pitch: math.Asin(math.Max(-1.0, math.Min(1.0, -r.M[2][0]))),
+		Yaw:   math.Atan2(r.M[1][0], r.M[0][0]),
+		Frame: r.Frame,
+	}
+}
+
+// Multiply returns the matrix product r * o, the result keeps the frame of r
+func (r RotationMatrix) Multiply(o RotationMatrix) RotationMatrix {
+	result := RotationMatrix{Frame: r.Frame}
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				result.M[i][j] += r.M[i][k] * o.M[k][j]
+			}
+		}
+	}
+
+	return result
+}
+
+// Transpose returns the transposed matrix, which is the inverse rotation
+func (r RotationMatrix) Transpose() RotationMatrix {
+	result := RotationMatrix{Frame: r.Frame}
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			result.M[i][j] = r.M[j][i]
+		}
+	}
+
+	return result
+}
+
+// Rotate rotates the given vector by the matrix
+func (r RotationMatrix) Rotate(v Vector3D) Vector3D {
+	return Vector3D{
+		X:     r.M[0][0]*v.X + r.M[0][1]*v.Y + r.M[0][2]*v.Z,
+		Y:     r.M[1][0]*v.X + r.M[1][1]*v.Y + r.M[1][2]*v.Z,
+		Z:     r.M[2][0]*v.X + r.M[2][1]*v.Y + r.M[2][2]*v.Z,
+		Frame: r.Frame,
+	}
+}
+
+// Orthonormalize removes the numerical drift of the matrix, restoring perpendicular unit rows
+func (r *RotationMatrix) Orthonormalize() {
+	x := Vector3D{X: r.M[0][0], Y: r.M[0][1], Z: r.M[0][2]}
+	y := Vector3D{X: r.M[1][0], Y: r.M[1][1], Z: r.M[1][2]}
+
+	// Share the orthogonality error between the first two rows, each pass squares the remaining error
+	for i := 0; i < 10; i++ {
+		x.Scale(1.0 / x.Norm())
+		y.Scale(1.0 / y.Norm())
+
+		e := x.Dot(y) / 2.0
+		if math.Abs(e) < 1e-15 {
+			break
+		}
+
+		x, y = Vector3D{X: x.X - e*y.X, Y: x.Y - e*y.Y, Z: x.Z - e*y.Z}, Vector3D{X: y.X - e*x.X, Y: y.Y - e*x.Y, Z: y.Z - e*x.Z}
+	}
+
+	// The third row is perpendicular to the first two by construction
+	z := x.Cross(y)
+	z.Scale(1.0 / z.Norm())
+
+	for i, row := range []Vector3D{x, y, z} {
+		r.M[i] = [3]float64{row.X, row.Y, row.Z}
+	}
+}
@@ -0,0 +1,92 @@
+package measurement
+
+import (
+	"math"
+	"testing"
+)
+
+// assertOrthonormal fails the test if the matrix times its transpose is not the identity
+func assertOrthonormal(t *testing.T, name string, m RotationMatrix) {
+	t.Helper()
+
+	product := m.Multiply(m.Transpose())
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			want := 0.0
+			if i == j {
+				want = 1.0
+			}
+			if math.Abs(product.M[i][j]-want) > testTolerance {
+				t.Errorf("%s: M * M^T [%d][%d] = %f, want %f", name, i, j, product.M[i][j], want)
+			}
+		}
+	}
+}
+
+func TestMatrixQuaternionRoundTrip(t *testing.T) {
+	// Each case exercises a different branch of Shepperd's method: the largest of the trace and the diagonal
+	tests := []struct {
+		name  string
+		axis  Vector3D
+		angle float64
+	}{
+		{"identity", Vector3D{Z: 1.0}, 0.0},
+		{"small", Vector3D{X: 1.0, Y: 2.0, Z: 3.0}, 0.4},
+		{"x half turn", Vector3D{X: 1.0}, math.Pi},
+		{"y half turn", Vector3D{Y: 1.0}, math.Pi},
+		{"z half turn", Vector3D{Z: 1.0}, math.Pi},
+		{"x large", Vector3D{X: 1.0, Y: 0.1, Z: -0.2}, 2.9},
+		{"y large", Vector3D{X: -0.1, Y: 1.0, Z: 0.2}, -2.9},
+		{"z large", Vector3D{X: 0.2, Y: -0.1, Z: 1.0}, 2.9},
+	}
+
+	for _, tt := range tests {
+		q := NewQuaternionFromAxisAngle(tt.axis, tt.angle)
+		m := q.GetAsMatrix()
+		got := m.GetAsQuaternion()
+
+		assertRotation(t, tt.name, got, q)
+		if got.Q0 < 0.0 {
+			t.Errorf("%s: scalar part is negative: %f", tt.name, got.Q0)
+		}
+
+		// The transpose is the inverse rotation
+		assertOrthonormal(t, tt.name, m)
+	}
+}
+
+func TestMatrixEuler(t *testing.T) {
+	tests := []EulerAngles{
+		{Roll: 0.0, Pitch: 0.0, Yaw: 0.0},
+		{Roll: 0.3, Pitch: -0.2, Yaw: 1.1},
+		{Roll: -2.5, Pitch: 1.2, Yaw: -3.0},
+	}
+
+	for _, e := range tests {
+		m := e.GetAsMatrix()
+		assertRotation(t, "euler matrix", m.GetAsQuaternion(), e.GetAsQuaternion())
+
+		got := m.GetAsEuler()
+		if math.Abs(got.Roll-e.Roll) > testTolerance || math.Abs(got.Pitch-e.Pitch) > testTolerance ||
+			math.Abs(got.Yaw-e.Yaw) > testTolerance {
+			t.Errorf("euler round trip: got %+v, want %+v", got, e)
+		}
+	}
+}
+
+func TestOrthonormalize(t *testing.T) {
+	m := NewQuaternionFromAxisAngle(Vector3D{X: 1.0, Y: -1.0, Z: 2.0}, 1.0).GetAsMatrix()
+	want := m.GetAsQuaternion()
+
+	// Drift of the magnitude expected from the chip output rounded to a few digits
+	m.M[0][1] += 1e-4
+	m.M[1][1] -= 2e-4
+	m.M[2][0] += 1e-4
+	m.Orthonormalize()
+
+	assertOrthonormal(t, "orthonormalize", m)
+
+	if got := m.GetAsQuaternion().AngularDistance(want); got > 1e-3 {
+		t.Errorf("orthonormalized matrix moved by %f radians", got)
+	}
+}
@@ -5,9 +5,10 @@ import (
 )
 
 type Vector3D struct {
-	X float64
-	Y float64
-	Z float64
+	X     float64
+	Y     float64
+	Z     float64
+	Frame Frame
 }
 
 // NewMagnetometer creates a new Magnetometer and returns its pointer.
@@ -23,22 +24,12 @@ func NewMagnetometer(x, y, z float64) *Vector3D {
 
 // GetRotated rotates the coords reading to magnetic north.
 func (m *Vector3D) GetRotated(o Quaternion) Vector3D {
-	e := o.GetAsEuler()
-
-	ny, nz := rotateX(m.Y, m.Z, e.Roll)
-	nx, nz := rotateY(m.X, nz, e.Pitch)
-	nx, ny = rotateZ(nx, ny, e.Yaw)
-
-	return Vector3D{X: nx, Y: ny, Z: nz}
+	return o.Rotate(*m)
 }
 
 // GetRotatedEuler rotates the coords reading to magnetic north based on given Euler angles.
 func (m *Vector3D) GetRotatedEuler(e EulerAngles) Vector3D {
-	ny, nz := rotateX(m.Y, m.Z, e.Roll)
-	nx, nz := rotateY(m.X, nz, e.Pitch)
-	nx, ny = rotateZ(nx, ny, e.Yaw)
-
-	return Vector3D{X: nx, Y: ny, Z: nz}
+	return e.GetAsQuaternion().Rotate(*m)
 }
 
 // IsEmpty checks if given vector is (0.0, 0.0, 0.0)
@@ -62,32 +53,22 @@ func (m Vector3D) SquareSum() float64 {
 	return math.Pow(m.X, 2) + math.Pow(m.Y, 2) + math.Pow(m.Z, 2)
 }
 
-// Rotates the point around the X axis by the given angle in radians.
-func rotateX(y, z, rad float64) (float64, float64) {
-	cosa := math.Cos(rad)
-	sina := math.Sin(rad)
-	ny := y*cosa - z*sina
-	nz := y*sina + z*cosa
-
-	return ny, nz
+// Norm returns the length of the vector
+func (m Vector3D) Norm() float64 {
+	return math.Sqrt(m.SquareSum())
 }
 
-// Rotates the point around the Y axis by the given angle in radians.
-func rotateY(x, z, rad float64) (float64, float64) {
-	cosa := math.Cos(rad)
-	sina := math.Sin(rad)
-	nz := z*cosa - x*sina
-	nx := z*sina + x*cosa
-
-	return nx, nz
+// Dot returns the dot product of the vectors
+func (m Vector3D) Dot(v Vector3D) float64 {
+	return m.X*v.X + m.Y*v.Y + m.Z*v.Z
 }
 
-// Rotates the point around the Z axis by the given angle in radians.
-func rotateZ(x, y, rad float64) (float64, float64) {
-	cosa := math.Cos(rad)
-	sina := math.Sin(rad)
-	nx := x*cosa - y*sina
-	ny := x*sina + y*cosa
-
-	return nx, ny
+// Cross returns the cross product m x v, the result keeps the frame of m
+func (m Vector3D) Cross(v Vector3D) Vector3D {
+	return Vector3D{
+		X:     m.Y*v.Z - m.Z*v.Y,
+		Y:     m.Z*v.X - m.X*v.Z,
+		Z:     m.X*v.Y - m.Y*v.X,
+		Frame: m.Frame,
+	}
 }
@@ -8,24 +8,95 @@ type EulerAngles struct {
 	Roll  float64
 	Pitch float64
 	Yaw   float64
+	Frame Frame
 }
 
 type Quaternion struct {
-	Q0 float64
-	Q1 float64
-	Q2 float64
-	Q3 float64
+	Q0    float64
+	Q1    float64
+	Q2    float64
+	Q3    float64
+	Frame Frame
 }
 
+// GetAsEuler returns the roll-pitch-yaw angles of the XSens aerospace sequence, in gimbal lock the roll is set to zero.
+// See GetAsEulerSequence for other conventions.
 func (q Quaternion) GetAsEuler() EulerAngles {
-	roll := math.Atan2(2*(q.Q0*q.Q1+q.Q2*q.Q3), 1-2*(q.Q1*q.Q1+q.Q2*q.Q2))
-	pitch := math.Asin(2.0 * (q.Q0*q.Q2 - q.Q3*q.Q1))
-	yaw := math.Atan2(2*(q.Q1*q.Q2+q.Q0*q.Q3), 1-2*(q.Q2*q.Q2+q.Q3*q.Q3))
+	angles, _ := q.GetAsEulerSequence(SequenceAerospace)
 
-	return EulerAngles{Roll: roll, Pitch: pitch, Yaw: yaw}
+	return EulerAngles{Roll: angles[2], Pitch: angles[1], Yaw: angles[0], Frame: q.Frame}
 }
 
-func (q Quaternion) Update(q0, q1, q2, q3 float64) {
+// GetAsQuaternion returns the quaternion of the roll-pitch-yaw rotation
+func (e EulerAngles) GetAsQuaternion() Quaternion {
+	cr, sr := math.Cos(e.Roll/2.0), math.Sin(e.Roll/2.0)
+	cp, sp := math.Cos(e.Pitch/2.0), math.Sin(e.Pitch/2.0)
+	cy, sy := math.Cos(e.Yaw/2.0), math.Sin(e.Yaw/2.0)
+
+	return Quaternion{
+		Q0:    cr*cp*cy + sr*sp*sy,
+		Q1:    sr*cp*cy - cr*sp*sy,
+		Q2:    cr*sp*cy + sr*cp*sy,
+		Q3:    cr*cp*sy - sr*sp*cy,
+		Frame: e.Frame,
+	}
+}
+
+// Multiply returns the Hamilton product q * r, the result keeps the frame of q
+func (q Quaternion) Multiply(r Quaternion) Quaternion {
+	return Quaternion{
+		Q0:    q.Q0*r.Q0 - q.Q1*r.Q1 - q.Q2*r.Q2 - q.Q3*r.Q3,
+		Q1:    q.Q0*r.Q1 + q.Q1*r.Q0 + q.Q2*r.Q3 - q.Q3*r.Q2,
+		Q2:    q.Q0*r.Q2 - q.Q1*r.Q3 + q.Q2*r.Q0 + q.Q3*r.Q1,
+		Q3:    q.Q0*r.Q3 + q.Q1*r.Q2 - q.Q2*r.Q1 + q.Q3*r.Q0,
+		Frame: q.Frame,
+	}
+}
+
+// Conjugate returns the conjugate of the quaternion
+func (q Quaternion) Conjugate() Quaternion {
+	return Quaternion{Q0: q.Q0, Q1: -q.Q1, Q2: -q.Q2, Q3: -q.Q3, Frame: q.Frame}
+}
+
+// GetAsRotationVector returns the rotation of a (unit) quaternion as axis * angle in radians
+func (q Quaternion) GetAsRotationVector() Vector3D {
+	sinHalf := math.Sqrt(math.Pow(q.Q1, 2) + math.Pow(q.Q2, 2) + math.Pow(q.Q3, 2))
+	if sinHalf == 0.0 {
+		return Vector3D{X: 0.0, Y: 0.0, Z: 0.0}
+	}
+
+	// atan2 keeps precision for the small angles of orientation increments
+	angle := 2.0 * math.Atan2(sinHalf, q.Q0)
+	result := Vector3D{X: q.Q1, Y: q.Q2, Z: q.Q3}
+	result.Scale(angle / sinHalf)
+
+	return result
+}
+
+// NewQuaternionFromAxisAngle returns the rotation around the given axis by the given angle in radians
+func NewQuaternionFromAxisAngle(axis Vector3D, angle float64) Quaternion {
+	norm := axis.Norm()
+	if norm == 0.0 {
+		return Quaternion{Q0: 1.0, Q1: 0.0, Q2: 0.0, Q3: 0.0, Frame: axis.Frame}
+	}
+
+	s := math.Sin(angle/2.0) / norm
+
+	return Quaternion{Q0: math.Cos(angle / 2.0), Q1: axis.X * s, Q2: axis.Y * s, Q3: axis.Z * s, Frame: axis.Frame}
+}
+
+// NewQuaternionFromRotationVector returns the rotation described by axis * angle in radians
+func NewQuaternionFromRotationVector(v Vector3D) Quaternion {
+	return NewQuaternionFromAxisAngle(v, v.Norm())
+}
+
+// NewQuaternionFromEuler returns the quaternion of the given roll-pitch-yaw angles
+func NewQuaternionFromEuler(e EulerAngles) Quaternion {
+	return e.GetAsQuaternion()
+}
+
+// Update sets all components of the quaternion
+func (q *Quaternion) Update(q0, q1, q2, q3 float64) {
 	q.Q0 = q0
 	q.Q1 = q1
 	q.Q2 = q2
@@ -44,3 +115,101 @@ func (q *Quaternion) Scale(factor float64) {
 	q.Q2 = factor * q.Q2
 	q.Q3 = factor * q.Q3
 }
+
+// Norm returns the length of the quaternion
+func (q Quaternion) Norm() float64 {
+	return math.Sqrt(q.SquareSum())
+}
+
+// Normalize scales the quaternion to unit length, a zero quaternion is set to identity
+func (q *Quaternion) Normalize() {
+	norm := q.Norm()
+	if norm == 0.0 {
+		q.Update(1.0, 0.0, 0.0, 0.0)
+		return
+	}
+
+	q.Scale(1.0 / norm)
+}
+
+// Dot returns the four dimensional dot product of the quaternions
+func (q Quaternion) Dot(r Quaternion) float64 {
+	return q.Q0*r.Q0 + q.Q1*r.Q1 + q.Q2*r.Q2 + q.Q3*r.Q3
+}
+
+// Inverse returns the multiplicative inverse of the quaternion
+func (q Quaternion) Inverse() Quaternion {
+	result := q.Conjugate()
+	result.Scale(1.0 / q.SquareSum())
+
+	return result
+}
+
+// Rotate rotates the given vector by the (unit) quaternion
+func (q Quaternion) Rotate(v Vector3D) Vector3D {
+	// v' = v + 2 * q0 * (u x v) + 2 * u x (u x v), with u the vector part of q
+	u := Vector3D{X: q.Q1, Y: q.Q2, Z: q.Q3}
+	t := u.Cross(v)
+	t.Scale(2.0)
+
+	result := u.Cross(t)
+	result.X += v.X + q.Q0*t.X
+	result.Y += v.Y + q.Q0*t.Y
+	result.Z += v.Z + q.Q0*t.Z
+	result.Frame = q.Frame
+
+	return result
+}
+
+// AngularDistance returns the angle in radians of the rotation between two (unit) quaternions
+func (q Quaternion) AngularDistance(r Quaternion) float64 {
+	// q and -q represent the same rotation
+	dot := math.Min(math.Abs(q.Dot(r)), 1.0)
+
+	return 2.0 * math.Acos(dot)
+}
+
+// Nlerp interpolates linearly between two (unit) quaternions along the shorter path and normalizes the result
+func (q Quaternion) Nlerp(r Quaternion, t float64) Quaternion {
+	if q.Dot(r) < 0.0 {
+		r.Scale(-1.0)
+	}
+
+	result := Quaternion{
+		Q0:    q.Q0 + t*(r.Q0-q.Q0),
+		Q1:    q.Q1 + t*(r.Q1-q.Q1),
+		Q2:    q.Q2 + t*(r.Q2-q.Q2),
+		Q3:    q.Q3 + t*(r.Q3-q.Q3),
+		Frame: q.Frame,
+	}
+	result.Normalize()
+
+	return result
+}
+
+// Slerp interpolates between two (unit) quaternions with constant angular velocity along the shorter path
+func (q Quaternion) Slerp(r Quaternion, t float64) Quaternion {
+	dot := q.Dot(r)
+	if dot < 0.0 {
+		r.Scale(-1.0)
+		dot = -dot
+	}
+
+	// Nearly parallel quaternions would divide by ~0, linear interpolation is accurate there
+	if dot > 0.9995 {
+		return q.Nlerp(r, t)
+	}
+
+	theta := math.Acos(dot)
+	sinTheta := math.Sin(theta)
+	wq := math.Sin((1.0-t)*theta) / sinTheta
+	wr := math.Sin(t*theta) / sinTheta
+
+	return Quaternion{
+		Q0:    wq*q.Q0 + wr*r.Q0,
+		Q1:    wq*q.Q1 + wr*r.Q1,
+		Q2:    wq*q.Q2 + wr*r.Q2,
+		Q3:    wq*q.Q3 + wr*r.Q3,
+		Frame: q.Frame,
+	}
+}
@@ -0,0 +1,124 @@
+package measurement
+
+import (
+	"math"
+	"testing"
+)
+
+const testTolerance = 1e-9
+
+// assertVector fails the test if the vectors differ by more than the tolerance in any component
+func assertVector(t *testing.T, name string, got, want Vector3D) {
+	t.Helper()
+
+	if math.Abs(got.X-want.X) > testTolerance || math.Abs(got.Y-want.Y) > testTolerance || math.Abs(got.Z-want.Z) > testTolerance {
+		t.Errorf("%s: got (%.6f, %.6f, %.6f), want (%.6f, %.6f, %.6f)", name, got.X, got.Y, got.Z, want.X, want.Y, want.Z)
+	}
+}
+
+// assertRotation fails the test if the quaternions describe different rotations, q and -q are equal
+func assertRotation(t *testing.T, name string, got, want Quaternion) {
+	t.Helper()
+
+	if math.Abs(math.Abs(got.Dot(want))-1.0) > testTolerance {
+		t.Errorf("%s: got %+v, want %+v", name, got, want)
+	}
+}
+
+func TestQuaternionRotate(t *testing.T) {
+	tests := []struct {
+		name  string
+		axis  Vector3D
+		angle float64
+		in    Vector3D
+		want  Vector3D
+	}{
+		{"identity", Vector3D{Z: 1.0}, 0.0, Vector3D{X: 1.0, Y: 2.0, Z: 3.0}, Vector3D{X: 1.0, Y: 2.0, Z: 3.0}},
+		{"z quarter turn", Vector3D{Z: 1.0}, math.Pi / 2.0, Vector3D{X: 1.0}, Vector3D{Y: 1.0}},
+		{"x quarter turn", Vector3D{X: 1.0}, math.Pi / 2.0, Vector3D{Y: 1.0}, Vector3D{Z: 1.0}},
+		{"y half turn", Vector3D{Y: 1.0}, math.Pi, Vector3D{X: 1.0, Z: 1.0}, Vector3D{X: -1.0, Z: -1.0}},
+		{"diagonal third turn", Vector3D{X: 1.0, Y: 1.0, Z: 1.0}, 2.0 * math.Pi / 3.0, Vector3D{X: 1.0}, Vector3D{Y: 1.0}},
+	}
+
+	for _, tt := range tests {
+		q := NewQuaternionFromAxisAngle(tt.axis, tt.angle)
+		assertVector(t, tt.name, q.Rotate(tt.in), tt.want)
+
+		// The matrix of the quaternion rotates the same way
+		assertVector(t, tt.name+" matrix", q.GetAsMatrix().Rotate(tt.in), tt.want)
+
+		// The inverse undoes the rotation
+		assertVector(t, tt.name+" inverse", q.Inverse().Rotate(q.Rotate(tt.in)), tt.in)
+	}
+}
+
+func TestQuaternionMultiply(t *testing.T) {
+	a := NewQuaternionFromAxisAngle(Vector3D{X: 1.0, Y: -2.0, Z: 0.5}, 0.7)
+	b := NewQuaternionFromAxisAngle(Vector3D{X: 0.3, Y: 1.0, Z: 2.0}, -1.9)
+	v := Vector3D{X: 0.2, Y: -1.0, Z: 3.0}
+
+	// Rotating by a * b is rotating by b first, then by a
+	assertVector(t, "composition", a.Multiply(b).Rotate(v), a.Rotate(b.Rotate(v)))
+	assertRotation(t, "inverse", a.Multiply(a.Inverse()), Quaternion{Q0: 1.0})
+	assertRotation(t, "conjugate", a.Multiply(a.Conjugate()), Quaternion{Q0: 1.0})
+}
+
+func TestRotationVectorRoundTrip(t *testing.T) {
+	tests := []Vector3D{
+		{X: 0.0, Y: 0.0, Z: 0.0},
+		{X: 1e-9, Y: 0.0, Z: 0.0},
+		{X: 0.1, Y: -0.2, Z: 0.3},
+		{X: 0.0, Y: 3.0, Z: 0.0},
+	}
+
+	for _, v := range tests {
+		got := NewQuaternionFromRotationVector(v).GetAsRotationVector()
+		assertVector(t, "rotation vector", got, v)
+	}
+}
+
+func TestSlerp(t *testing.T) {
+	from := NewQuaternionFromAxisAngle(Vector3D{Z: 1.0}, 0.2)
+	to := NewQuaternionFromAxisAngle(Vector3D{Z: 1.0}, 1.4)
+
+	tests := []struct {
+		t    float64
+		want float64
+	}{
+		{0.0, 0.2},
+		{0.25, 0.5},
+		{0.5, 0.8},
+		{1.0, 1.4},
+	}
+
+	for _, tt := range tests {
+		got := from.Slerp(to, tt.t)
+		assertRotation(t, "slerp", got, NewQuaternionFromAxisAngle(Vector3D{Z: 1.0}, tt.want))
+
+		// The negated end quaternion is the same rotation, the shorter path is taken
+		to.Scale(-1.0)
+		assertRotation(t, "slerp negated", from.Slerp(to, tt.t), got)
+		to.Scale(-1.0)
+	}
+
+	// Nearly parallel quaternions fall back to the normalized linear interpolation
+	near := NewQuaternionFromAxisAngle(Vector3D{Z: 1.0}, 0.2001)
+	got := from.Slerp(near, 0.5)
+	if math.Abs(got.Norm()-1.0) > testTolerance {
+		t.Errorf("slerp of nearly parallel quaternions is not unit: %f", got.Norm())
+	}
+}
+
+func TestAngularDistance(t *testing.T) {
+	a := NewQuaternionFromAxisAngle(Vector3D{X: 1.0, Y: 1.0}, 0.3)
+	b := a.Multiply(NewQuaternionFromAxisAngle(Vector3D{Z: 1.0}, 0.5))
+
+	if got := a.AngularDistance(b); math.Abs(got-0.5) > testTolerance {
+		t.Errorf("angular distance: got %f, want 0.5", got)
+	}
+
+	b.Scale(-1.0)
+	if got := a.AngularDistance(b); math.Abs(got-0.5) > testTolerance {
+		t.Errorf("angular distance of negated quaternion: got %f, want 0.5", got)
+	}
+}
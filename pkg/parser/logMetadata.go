@@ -0,0 +1,150 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+)
+
+// LogMetadata holds the device and recording information of the "//" comment header of an XSens log
+type LogMetadata struct {
+	MTManagerVersion string
+	XDAVersion       string
+	DeviceID         string
+	ProductCode      string
+	FirmwareVersion  string
+	HardwareVersion  string
+	FilterProfile    string
+	OptionFlags      string
+	CoordinateSystem string
+	StartTime        string
+	UpdateRate       string
+	Other            map[string]string
+}
+
+// NewLogMetadata is the constructor.
+func NewLogMetadata() *LogMetadata {
+	l := LogMetadata{
+		Other: make(map[string]string),
+	}
+
+	return &l
+}
+
+// ParseLine fills the metadata from a single comment line, section titles and non comment lines are ignored
+func (l *LogMetadata) ParseLine(line string) {
+	if !strings.HasPrefix(line, "//") {
+		return
+	}
+
+	chunks := strings.SplitN(strings.TrimPrefix(line, "//"), ":", 2)
+	if len(chunks) != 2 {
+		return
+	}
+
+	key := strings.TrimSpace(chunks[0])
+	value := strings.TrimSpace(chunks[1])
+	if value == "" {
+		return
+	}
+
+	switch strings.ToLower(key) {
+	case "mt manager version":
+		l.MTManagerVersion = value
+	case "xda version":
+		l.XDAVersion = value
+	case "deviceid":
+		l.DeviceID = value
+	case "productcode":
+		l.ProductCode = value
+	case "firmware version":
+		l.FirmwareVersion = value
+	case "hardware version":
+		l.HardwareVersion = value
+	case "filter profile":
+		l.FilterProfile = value
+	case "option flags":
+		l.OptionFlags = value
+	case "coordinate system":
+		l.CoordinateSystem = value
+	case "start time":
+		l.StartTime = value
+	case "update rate":
+		l.UpdateRate = value
+	default:
+		l.Other[key] = value
+	}
+}
+
+// GetFrame returns the coordinate frame named in the header, FrameUnknown if the header does not name one
+func (l LogMetadata) GetFrame() (measurement.Frame, error) {
+	if l.CoordinateSystem == "" {
+		return measurement.FrameUnknown, nil
+	}
+
+	return measurement.ParseFrame(l.CoordinateSystem)
+}
+
+// metadataField is a known metadata field with its name in the header
+type metadataField struct {
+	name  string
+	value string
+}
+
+// getKnownFields returns the known metadata fields in header order
+func (l LogMetadata) getKnownFields() []metadataField {
+	return []metadataField{
+		{"MT Manager version", l.MTManagerVersion},
+		{"XDA version", l.XDAVersion},
+		{"DeviceId", l.DeviceID},
+		{"ProductCode", l.ProductCode},
+		{"Firmware version", l.FirmwareVersion},
+		{"Hardware version", l.HardwareVersion},
+		{"Filter profile", l.FilterProfile},
+		{"Option flags", l.OptionFlags},
+		{"Coordinate system", l.CoordinateSystem},
+		{"Start time", l.StartTime},
+		{"Update rate", l.UpdateRate},
+	}
+}
+
+// GetFields returns the metadata fields present in the header by their header name
+func (l LogMetadata) GetFields() map[string]string {
+	result := make(map[string]string)
+
+	for _, f := range l.getKnownFields() {
+		if f.value != "" {
+			result[f.name] = f.value
+		}
+	}
+
+	for key, value := range l.Other {
+		result[key] = value
+	}
+
+	return result
+}
+
+// String returns the known metadata fields, one per line
+func (l LogMetadata) String() string {
+	var b strings.Builder
+	for _, f := range l.getKnownFields() {
+		if f.value != "" {
+			fmt.Fprintf(&b, "%s: %s\n", f.name, f.value)
+		}
+	}
+
+	keys := make([]string, 0, len(l.Other))
+	for key := range l.Other {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%s: %s\n", key, l.Other[key])
+	}
+
+	return b.String()
+}
@@ -0,0 +1,156 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrMissingField is returned for rows having fewer fields than the header requires
+var ErrMissingField = errors.New("missing field")
+
+// maxCollectedErrors limits the errors kept in the report of a lenient parse, all errors are counted
+const maxCollectedErrors = 100
+
+// ParseError is a malformed cell of a log. Line is 1-based, Index is the 0-based column index and Column the
+// header name of the column, if known.
+type ParseError struct {
+	File   string
+	Line   int
+	Index  int
+	Column string
+	Cell   string
+	Err    error
+}
+
+// newCellError returns the error of an invalid cell, number parsing errors are reduced to their cause
+func newCellError(chunks []string, idx int, err error) *ParseError {
+	var numerr *strconv.NumError
+	if errors.As(err, &numerr) {
+		err = numerr.Err
+	}
+
+	return &ParseError{Index: idx, Cell: chunks[idx], Err: err}
+}
+
+// getCell returns a cell of the row
+func getCell(chunks []string, idx int) (string, error) {
+	if idx >= len(chunks) {
+		return "", &ParseError{Index: idx, Err: ErrMissingField}
+	}
+
+	return chunks[idx], nil
+}
+
+// parseFloatCell parses a cell of the row as float64
+func parseFloatCell(chunks []string, idx int) (float64, error) {
+	cell, err := getCell(chunks, idx)
+	if err != nil {
+		return 0.0, err
+	}
+
+	value, err := strconv.ParseFloat(cell, 64)
+	if err != nil {
+		return 0.0, newCellError(chunks, idx, err)
+	}
+
+	return value, nil
+}
+
+func (e *ParseError) Error() string {
+	var b strings.Builder
+
+	if e.File != "" {
+		fmt.Fprintf(&b, "%s:", e.File)
+	}
+	if e.Line > 0 {
+		fmt.Fprintf(&b, "%d:", e.Line)
+	}
+	if b.Len() > 0 {
+		b.WriteString(" ")
+	}
+
+	fmt.Fprintf(&b, "%s: %s", e.getColumnName(), e.Err.Error())
+	if !errors.Is(e.Err, ErrMissingField) {
+		fmt.Fprintf(&b, " %q", e.Cell)
+	}
+
+	return b.String()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// getColumnName returns the header name of the column, or its 1-based number if the header does not name it
+func (e *ParseError) getColumnName() string {
+	if e.Column != "" {
+		return "column " + e.Column
+	}
+
+	return fmt.Sprintf("column %d", e.Index+1)
+}
+
+// getReason returns the column and the cause of the error, the cell value left out
+func (e *ParseError) getReason() string {
+	return e.getColumnName() + ": " + e.Err.Error()
+}
+
+// DropReport summarizes the rows skipped by a lenient parse: the number of rows, the number of rows per reason
+// and the first errors
+type DropReport struct {
+	Rows    int
+	Reasons map[string]int
+	Errors  []*ParseError
+}
+
+// NewDropReport is the constructor.
+func NewDropReport() *DropReport {
+	d := DropReport{
+		Reasons: make(map[string]int),
+		Errors:  make([]*ParseError, 0),
+	}
+
+	return &d
+}
+
+// add records a skipped row
+func (d *DropReport) add(err *ParseError) {
+	d.Rows++
+	d.Reasons[err.getReason()]++
+
+	if len(d.Errors) < maxCollectedErrors {
+		d.Errors = append(d.Errors, err)
+	}
+}
+
+// String returns the number of skipped rows and the reasons, most frequent first
+func (d DropReport) String() string {
+	if d.Rows == 0 {
+		return "No rows skipped\n"
+	}
+
+	reasons := make([]string, 0, len(d.Reasons))
+	for reason := range d.Reasons {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if d.Reasons[reasons[i]] != d.Reasons[reasons[j]] {
+			return d.Reasons[reasons[i]] > d.Reasons[reasons[j]]
+		}
+		return reasons[i] < reasons[j]
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Skipped %d malformed rows\n", d.Rows)
+	for _, reason := range reasons {
+		fmt.Fprintf(&b, "  %d: %s\n", d.Reasons[reason], reason)
+	}
+	if len(d.Errors) > 0 {
+		fmt.Fprintf(&b, "First: %s\n", d.Errors[0])
+	}
+
+	return b.String()
+}
@@ -0,0 +1,404 @@
+package parser
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+)
+
+// Sample is one row of an XSens log. Series the log does not carry are left zero, a row without magnetometer
+// reading has an empty Magneto. Gyro and Accelero are derived from the increments if the log has no calibrated
+// inertial data.
+type Sample struct {
+	Index          int
+	PacketCounter  int
+	Timestamp      float64
+	DeltaT         float64
+	Accelero       measurement.Vector3D
+	Gyro           measurement.Vector3D
+	Magneto        measurement.Vector3D
+	VelInc         measurement.Vector3D
+	OriInc         measurement.Quaternion
+	QuatOri        measurement.Quaternion
+	MatOri         measurement.RotationMatrix
+	EulerOri       measurement.EulerAngles
+	RotatedMagneto measurement.Vector3D
+}
+
+// columns holds the index of the first column of each series, -1 if the log does not carry it
+type columns struct {
+	counter, time             int
+	acc, gyr, mag, euler      int
+	velInc, oriInc, quat, mat int
+}
+
+// SampleReader reads an XSens log row by row, in constant memory. File names the log in the parse errors. In
+// Lenient mode malformed rows are skipped and recorded in Dropped instead of failing the read.
+type SampleReader struct {
+	Metadata LogMetadata
+	Header   []string
+	Frame    measurement.Frame
+	File     string
+	Lenient  bool
+	Dropped  DropReport
+	reader   *bufio.Reader
+	line     int
+	columns  columns
+	index    int
+	ticks    uint64
+	wraps    uint64
+	previous float64
+	period   float64
+	pending  *Sample
+	err      error
+}
+
+// NewSampleReader reads the metadata and the header of the log. A frame other than FrameUnknown overrides the
+// coordinate system named in the header.
+func NewSampleReader(r io.Reader, frame measurement.Frame) (*SampleReader, error) {
+	s := SampleReader{
+		Metadata: *NewLogMetadata(),
+		Header:   make([]string, 0),
+		Frame:    frame,
+		Dropped:  *NewDropReport(),
+		reader:   bufio.NewReader(r),
+	}
+
+	for {
+		chunks, err := s.readLine()
+		if err == io.EOF {
+			return nil, errors.New("Required fields not found in file")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(chunks) == 1 {
+			s.Metadata.ParseLine(chunks[0])
+			continue
+		}
+
+		if len(chunks) > 1 {
+			s.Header = chunks
+			break
+		}
+	}
+
+	s.columns = columns{
+		counter: indexOf("PacketCounter", s.Header),
+		time:    indexOf("SampleTimeFine", s.Header),
+		acc:     indexOf("Acc_X", s.Header),
+		gyr:     indexOf("Gyr_X", s.Header),
+		mag:     indexOf("Mag_X", s.Header),
+		euler:   indexOf("Roll", s.Header),
+		velInc:  indexOf("VelInc_X", s.Header),
+		oriInc:  indexOf("OriInc_q0", s.Header),
+		quat:    indexOf("Quat_q0", s.Header),
+		mat:     indexOf("Mat[1][1]", s.Header),
+	}
+
+	// Strapdown integration outputs can stand in for the calibrated inertial data
+	c := s.columns
+	if (c.euler == -1 && c.quat == -1 && c.mat == -1) ||
+		(c.acc == -1 && c.velInc == -1) || (c.gyr == -1 && c.oriInc == -1) {
+		return nil, errors.New("Required fields not found in file")
+	}
+
+	// A frame set before parsing overrides the header
+	if s.Frame == measurement.FrameUnknown {
+		var err error
+		s.Frame, err = s.Metadata.GetFrame()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &s, nil
+}
+
+// HasTimestamps checks if the log has the SampleTimeFine column
+func (s *SampleReader) HasTimestamps() bool {
+	return s.columns.time != -1
+}
+
+// HasPacketCounter checks if the log has the PacketCounter column
+func (s *SampleReader) HasPacketCounter() bool {
+	return s.columns.counter != -1
+}
+
+// HasAccelero checks if the log has calibrated accelerometer readings, otherwise they are derived from VelInc
+func (s *SampleReader) HasAccelero() bool {
+	return s.columns.acc != -1
+}
+
+// HasGyro checks if the log has calibrated gyroscope readings, otherwise they are derived from OriInc
+func (s *SampleReader) HasGyro() bool {
+	return s.columns.gyr != -1
+}
+
+// HasVelInc checks if the log has velocity increments
+func (s *SampleReader) HasVelInc() bool {
+	return s.columns.velInc != -1
+}
+
+// HasOriInc checks if the log has orientation increments
+func (s *SampleReader) HasOriInc() bool {
+	return s.columns.oriInc != -1
+}
+
+// HasQuatOri checks if the log has the quaternion orientation output of the chip
+func (s *SampleReader) HasQuatOri() bool {
+	return s.columns.quat != -1
+}
+
+// HasMatOri checks if the log has the rotation matrix orientation output of the chip
+func (s *SampleReader) HasMatOri() bool {
+	return s.columns.mat != -1
+}
+
+// readLine returns the tab separated cells of the next line. XSens logs do not quote, unlike csv.Reader the
+// cells are taken as is.
+func (s *SampleReader) readLine() ([]string, error) {
+	line, err := s.reader.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.line++
+
+	return strings.Split(strings.TrimRight(line, "\r\n"), "\t"), nil
+}
+
+// readRow parses the next data row, the increments are not yet turned into rates
+func (s *SampleReader) readRow() (Sample, error) {
+	for {
+		chunks, err := s.readLine()
+		if err != nil {
+			return Sample{}, err
+		}
+
+		if len(chunks) <= 1 {
+			continue
+		}
+
+		result, err := s.parseRow(chunks)
+		if err == nil {
+			return result, nil
+		}
+
+		// Locate the invalid cell
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			return result, err
+		}
+		perr.File = s.File
+		perr.Line = s.line
+		if perr.Index < len(s.Header) {
+			perr.Column = s.Header[perr.Index]
+		}
+
+		if !s.Lenient {
+			return result, perr
+		}
+		s.Dropped.add(perr)
+	}
+}
+
+// parseRow parses the cells of a data row, the state of the reader only changes if the row is valid
+func (s *SampleReader) parseRow(chunks []string) (Sample, error) {
+	c := s.columns
+	result := Sample{Index: s.index}
+	var err error
+
+	if c.counter != -1 {
+		cell, err := getCell(chunks, c.counter)
+		if err != nil {
+			return result, err
+		}
+
+		result.PacketCounter, err = strconv.Atoi(cell)
+		if err != nil {
+			return result, newCellError(chunks, c.counter, err)
+		}
+	}
+
+	ticks, wraps := s.ticks, s.wraps
+	if c.time != -1 {
+		cell, err := getCell(chunks, c.time)
+		if err != nil {
+			return result, err
+		}
+
+		ticks, err = strconv.ParseUint(cell, 10, 32)
+		if err != nil {
+			return result, newCellError(chunks, c.time, err)
+		}
+
+		// SampleTimeFine is a 32 bit counter, unwrap it on overflow. A small step back is a glitch of the log, not
+		// an overflow, its sample interval is replaced in Next.
+		if ticks < s.ticks && s.ticks-ticks > 1<<31 {
+			wraps++
+		}
+
+		result.Timestamp = float64(wraps<<32+ticks) / sampleTimeFineHz
+		if s.index > 0 {
+			result.DeltaT = result.Timestamp - s.previous
+		}
+	}
+
+	// The interval of the next sample is measured from the last timestamp that advanced, a glitch must not
+	// lengthen it
+	advanced := s.index == 0 || result.Timestamp > s.previous
+
+	if c.acc != -1 {
+		result.Accelero, err = GetFloatVector3D(chunks, c.acc)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if c.velInc != -1 {
+		result.VelInc, err = GetFloatVector3D(chunks, c.velInc)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if c.gyr != -1 {
+		result.Gyro, err = GetFloatVector3D(chunks, c.gyr)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if c.oriInc != -1 {
+		result.OriInc, err = GetFloatQuaternion(chunks, c.oriInc)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	// Magnetometer is sampled at a lower rate or not logged at all, missing readings are stored as empty vectors
+	result.Magneto = measurement.Vector3D{X: 0.0, Y: 0.0, Z: 0.0}
+	if c.mag != -1 {
+		cell, err := getCell(chunks, c.mag)
+		if err != nil {
+			return result, err
+		}
+		if cell != "" {
+			result.Magneto, err = GetFloatVector3D(chunks, c.mag)
+			if err != nil {
+				return result, err
+			}
+		}
+	}
+
+	if c.quat != -1 {
+		result.QuatOri, err = GetFloatQuaternion(chunks, c.quat)
+		if err != nil {
+			return result, err
+		}
+		result.QuatOri.Frame = s.Frame
+	}
+
+	if c.mat != -1 {
+		result.MatOri, err = GetFloatMatrix(chunks, c.mat)
+		if err != nil {
+			return result, err
+		}
+		result.MatOri.Frame = s.Frame
+	}
+
+	if c.euler != -1 {
+		result.EulerOri, err = GetFloatEuler(chunks, c.euler)
+		if err != nil {
+			return result, err
+		}
+		result.EulerOri.Frame = s.Frame
+	} else if c.quat != -1 {
+		result.EulerOri = result.QuatOri.GetAsEuler()
+	} else {
+		result.EulerOri = result.MatOri.GetAsEuler()
+	}
+
+	result.RotatedMagneto = result.Magneto.GetRotatedEuler(result.EulerOri)
+
+	if advanced {
+		s.ticks, s.wraps = ticks, wraps
+		s.previous = result.Timestamp
+	}
+	s.index++
+
+	return result, nil
+}
+
+// Next returns the next sample of the log, or io.EOF after the last one. The sample interval of the first
+// sample is taken from the second one. Logs without timestamps use the default sampling frequency, samples whose
+// interval is not positive the previous interval.
+func (s *SampleReader) Next() (Sample, error) {
+	if s.pending == nil && s.err == nil && s.index == 0 {
+		first, err := s.readRow()
+		if err != nil {
+			s.err = err
+			return first, err
+		}
+		s.pending = &first
+	}
+
+	if s.pending == nil {
+		if s.err == nil {
+			s.err = io.EOF
+		}
+		return Sample{}, s.err
+	}
+
+	// One row is read ahead for the interval of the first sample
+	result := *s.pending
+	s.pending = nil
+	next, err := s.readRow()
+	if err != nil {
+		s.err = err
+	} else {
+		s.pending = &next
+	}
+
+	if !s.HasTimestamps() {
+		result.DeltaT = 1.0 / DefaultSamplingFrequency
+	} else if result.Index == 0 {
+		result.DeltaT = 1.0 / DefaultSamplingFrequency
+		if s.pending != nil {
+			result.DeltaT = s.pending.Timestamp - result.Timestamp
+		}
+	}
+
+	// A repeated or backward SampleTimeFine gives no usable interval to derive rates with, the last usable one
+	// stands in for the nominal sampling period
+	if result.DeltaT <= 0.0 || math.IsNaN(result.DeltaT) {
+		result.DeltaT = 1.0 / DefaultSamplingFrequency
+		if s.period > 0.0 {
+			result.DeltaT = s.period
+		}
+	} else {
+		s.period = result.DeltaT
+	}
+
+	// Derive inertial data from the increments
+	if !s.HasGyro() {
+		result.Gyro = result.OriInc.GetAsRotationVector()
+		result.Gyro.Scale(1.0 / result.DeltaT)
+	}
+
+	if !s.HasAccelero() {
+		result.Accelero = result.VelInc
+		result.Accelero.Scale(1.0 / result.DeltaT)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,294 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+)
+
+const (
+	testTolerance = 1e-9
+	testHeader    = "PacketCounter\tSampleTimeFine\tAcc_X\tAcc_Y\tAcc_Z\tGyr_X\tGyr_Y\tGyr_Z\tRoll\tPitch\tYaw"
+)
+
+// getLog returns an in-memory log of the header and the rows
+func getLog(header string, rows ...string) *strings.Reader {
+	lines := append([]string{"// DeviceId: 01234567", header}, rows...)
+
+	return strings.NewReader(strings.Join(lines, "\n") + "\n")
+}
+
+// getRows returns the rows of a sensor at rest with the given SampleTimeFine values
+func getRows(ticks ...uint64) []string {
+	result := make([]string, 0, len(ticks))
+	for idx, t := range ticks {
+		result = append(result, fmt.Sprintf("%d\t%d\t0.0\t0.0\t9.81\t0.0\t0.0\t0.0\t0.0\t0.0\t0.0", idx, t))
+	}
+
+	return result
+}
+
+// readAll returns the samples of the log up to the first error, io.EOF is not an error
+func readAll(t *testing.T, s *SampleReader) ([]Sample, error) {
+	t.Helper()
+
+	result := make([]Sample, 0)
+	for {
+		sample, err := s.Next()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+		result = append(result, sample)
+	}
+}
+
+func TestSampleReaderIntervals(t *testing.T) {
+	nominal := 1.0 / DefaultSamplingFrequency
+
+	tests := []struct {
+		name       string
+		header     string
+		rows       []string
+		timestamps []float64
+		intervals  []float64
+	}{
+		{
+			// The first sample takes its interval from the second one
+			name:       "regular",
+			header:     testHeader,
+			rows:       getRows(1000, 1100, 1200),
+			timestamps: []float64{0.1, 0.11, 0.12},
+			intervals:  []float64{0.01, 0.01, 0.01},
+		},
+		{
+			name:       "counter overflow",
+			header:     testHeader,
+			rows:       getRows(1<<32-150, 1<<32-50, 50, 150),
+			timestamps: []float64{429496.7146, 429496.7246, 429496.7346, 429496.7446},
+			intervals:  []float64{0.01, 0.01, 0.01, 0.01},
+		},
+		{
+			// The glitched sample gets the last interval, the next one is measured from the last advancing timestamp
+			name:       "backward glitch",
+			header:     testHeader,
+			rows:       getRows(1000, 1100, 1050, 1200, 1300),
+			timestamps: []float64{0.1, 0.11, 0.105, 0.12, 0.13},
+			intervals:  []float64{0.01, 0.01, 0.01, 0.01, 0.01},
+		},
+		{
+			name:       "repeated timestamp",
+			header:     testHeader,
+			rows:       getRows(1000, 1100, 1100, 1200),
+			timestamps: []float64{0.1, 0.11, 0.11, 0.12},
+			intervals:  []float64{0.01, 0.01, 0.01, 0.01},
+		},
+		{
+			// Without a usable interval yet the nominal period stands in
+			name:       "repeated first timestamp",
+			header:     testHeader,
+			rows:       getRows(1000, 1000, 1100),
+			timestamps: []float64{0.1, 0.1, 0.11},
+			intervals:  []float64{nominal, nominal, 0.01},
+		},
+		{
+			name:   "no timestamps",
+			header: "PacketCounter\tAcc_X\tAcc_Y\tAcc_Z\tGyr_X\tGyr_Y\tGyr_Z\tRoll\tPitch\tYaw",
+			rows: []string{
+				"0\t0.0\t0.0\t9.81\t0.0\t0.0\t0.0\t0.0\t0.0\t0.0",
+				"1\t0.0\t0.0\t9.81\t0.0\t0.0\t0.0\t0.0\t0.0\t0.0",
+			},
+			timestamps: []float64{0.0, 0.0},
+			intervals:  []float64{nominal, nominal},
+		},
+	}
+
+	for _, tt := range tests {
+		s, err := NewSampleReader(getLog(tt.header, tt.rows...), measurement.FrameUnknown)
+		if err != nil {
+			t.Fatalf("%s: unable to read header: %s", tt.name, err.Error())
+		}
+
+		samples, err := readAll(t, s)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.name, err.Error())
+		}
+
+		if len(samples) != len(tt.intervals) {
+			t.Fatalf("%s: got %d samples, want %d", tt.name, len(samples), len(tt.intervals))
+		}
+
+		for idx, sample := range samples {
+			if sample.Index != idx {
+				t.Errorf("%s: sample %d has index %d", tt.name, idx, sample.Index)
+			}
+			if math.Abs(sample.Timestamp-tt.timestamps[idx]) > testTolerance {
+				t.Errorf("%s: timestamp of sample %d: got %f, want %f", tt.name, idx, sample.Timestamp, tt.timestamps[idx])
+			}
+			if math.Abs(sample.DeltaT-tt.intervals[idx]) > testTolerance {
+				t.Errorf("%s: interval of sample %d: got %f, want %f", tt.name, idx, sample.DeltaT, tt.intervals[idx])
+			}
+		}
+	}
+}
+
+func TestSampleReaderIncrements(t *testing.T) {
+	header := "SampleTimeFine\tVelInc_X\tVelInc_Y\tVelInc_Z\tOriInc_q0\tOriInc_q1\tOriInc_q2\tOriInc_q3\tRoll\tPitch\tYaw"
+
+	// 0.001 rad around X and 0.0981 m/s up in every 10 ms
+	q := measurement.NewQuaternionFromRotationVector(measurement.Vector3D{X: 0.001})
+	rows := make([]string, 0)
+	for _, ticks := range []int{1000, 1100, 1100, 1200} {
+		rows = append(rows, fmt.Sprintf("%d\t0.0\t0.0\t0.0981\t%f\t%f\t%f\t%f\t0.0\t0.0\t0.0", ticks, q.Q0, q.Q1, q.Q2, q.Q3))
+	}
+
+	s, err := NewSampleReader(getLog(header, rows...), measurement.FrameUnknown)
+	if err != nil {
+		t.Fatalf("unable to read header: %s", err.Error())
+	}
+
+	samples, err := readAll(t, s)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	// The repeated timestamp must not give infinite rates
+	for idx, sample := range samples {
+		if math.Abs(sample.Gyro.X-0.1) > 1e-6 || math.Abs(sample.Accelero.Z-9.81) > 1e-6 {
+			t.Errorf("sample %d: got gyro %+v, accelero %+v", idx, sample.Gyro, sample.Accelero)
+		}
+	}
+}
+
+func TestSampleReaderStopsAtFirstError(t *testing.T) {
+	rows := append([]string{"0\t1000\tx\t0.0\t9.81\t0.0\t0.0\t0.0\t0.0\t0.0\t0.0"}, getRows(1100, 1200)...)
+
+	s, err := NewSampleReader(getLog(testHeader, rows...), measurement.FrameUnknown)
+	if err != nil {
+		t.Fatalf("unable to read header: %s", err.Error())
+	}
+
+	// Reading on after the error must not skip the malformed row
+	for i := 0; i < 2; i++ {
+		_, err = s.Next()
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Line != 3 {
+			t.Errorf("call %d: got error %v, want the parse error of line 3", i+1, err)
+		}
+	}
+}
+
+func TestSampleReaderParseError(t *testing.T) {
+	tests := []struct {
+		name   string
+		row    string
+		column string
+		cell   string
+		err    error
+	}{
+		{
+			name:   "invalid float",
+			row:    "2\t1200\t0.0\t0.0\t9.8.1\t0.0\t0.0\t0.0\t0.0\t0.0\t0.0",
+			column: "Acc_Z",
+			cell:   "9.8.1",
+			err:    strconv.ErrSyntax,
+		},
+		{
+			// The row ends before the orientation, reading it must not panic
+			name:   "short row",
+			row:    "2\t1200\t0.0\t0.0\t9.81\t0.0\t0.0\t0.0",
+			column: "Roll",
+			cell:   "",
+			err:    ErrMissingField,
+		},
+	}
+
+	for _, tt := range tests {
+		rows := append(getRows(1000, 1100), tt.row)
+
+		s, err := NewSampleReader(getLog(testHeader, rows...), measurement.FrameUnknown)
+		if err != nil {
+			t.Fatalf("%s: unable to read header: %s", tt.name, err.Error())
+		}
+		s.File = "test.txt"
+
+		samples, err := readAll(t, s)
+
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Errorf("%s: got error %v, want a parse error", tt.name, err)
+			continue
+		}
+
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got cause %v, want %v", tt.name, perr.Err, tt.err)
+		}
+
+		if perr.File != "test.txt" || perr.Line != 5 || perr.Column != tt.column || perr.Cell != tt.cell {
+			t.Errorf("%s: got %s:%d column %q cell %q, want test.txt:5 column %q cell %q", tt.name, perr.File,
+				perr.Line, perr.Column, perr.Cell, tt.column, tt.cell)
+		}
+
+		// The samples before the malformed row are still returned
+		if len(samples) != 2 {
+			t.Errorf("%s: got %d samples before the error, want 2", tt.name, len(samples))
+		}
+	}
+}
+
+func TestSampleReaderLenient(t *testing.T) {
+	rows := getRows(1000, 1100, 1200, 1300, 1400)
+	rows[1] = "1\t1100\t0.0\tnan?\t9.81\t0.0\t0.0\t0.0\t0.0\t0.0\t0.0"
+	rows[3] = "3\t1300\t0.0"
+
+	s, err := NewSampleReader(getLog(testHeader, rows...), measurement.FrameUnknown)
+	if err != nil {
+		t.Fatalf("unable to read header: %s", err.Error())
+	}
+	s.Lenient = true
+
+	samples, err := readAll(t, s)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if len(samples) != 3 {
+		t.Errorf("got %d samples, want 3", len(samples))
+	}
+
+	// The skipped rows do not shorten the intervals of the remaining ones
+	for idx, sample := range samples {
+		if math.Abs(sample.DeltaT-0.02) > testTolerance {
+			t.Errorf("interval of sample %d: got %f, want 0.02", idx, sample.DeltaT)
+		}
+	}
+
+	if s.Dropped.Rows != 2 {
+		t.Errorf("dropped rows: got %d, want 2", s.Dropped.Rows)
+	}
+
+	reasons := map[string]int{
+		"column Acc_Y: invalid syntax": 1,
+		"column Acc_Y: missing field":  1,
+	}
+	if len(s.Dropped.Reasons) != len(reasons) {
+		t.Errorf("reasons: got %v, want %v", s.Dropped.Reasons, reasons)
+	}
+	for reason, count := range reasons {
+		if s.Dropped.Reasons[reason] != count {
+			t.Errorf("reason %q: got %d, want %d", reason, s.Dropped.Reasons[reason], count)
+		}
+	}
+
+	if len(s.Dropped.Errors) != 2 || s.Dropped.Errors[0].Line != 4 || s.Dropped.Errors[1].Line != 6 {
+		t.Errorf("dropped errors: got %v, want lines 4 and 6", s.Dropped.Errors)
+	}
+}
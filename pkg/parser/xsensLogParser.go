@@ -1,43 +1,59 @@
 package parser
 
 import (
-	"encoding/csv"
-	"errors"
 	"fmt"
+	"io"
 	"math"
 	"os"
-	"strconv"
 
-	"github.com/ptrngy/xsens_rotate/pkg/imu"
 	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+	"github.com/ptrngy/xsens_rotate/pkg/recording"
 )
 
+// sampleTimeFineHz is the tick rate of the XSens SampleTimeFine counter.
+const sampleTimeFineHz = 10000.0
+
+// DefaultSamplingFrequency is assumed when a log carries no SampleTimeFine column.
+const DefaultSamplingFrequency = recording.DefaultSamplingFrequency
+
 type XSensLogParser struct {
-	Path               string
-	Header             []string
-	Accelero           []measurement.Vector3D
-	Gyro               []measurement.Vector3D
-	Magneto            []measurement.Vector3D
-	EulerOri           []measurement.EulerAngles
-	RotatedMagneto     []measurement.Vector3D
-	IMUOri             []measurement.EulerAngles
-	IMURotatedMagneto  []measurement.Vector3D
-	WarmRotatedMagneto []measurement.Vector3D
+	Path           string
+	Metadata       LogMetadata
+	Frame          measurement.Frame
+	Lenient        bool
+	Dropped        DropReport
+	Header         []string
+	PacketCounter  []int
+	Timestamps     []float64
+	Accelero       []measurement.Vector3D
+	Gyro           []measurement.Vector3D
+	Magneto        []measurement.Vector3D
+	VelInc         []measurement.Vector3D
+	OriInc         []measurement.Quaternion
+	QuatOri        []measurement.Quaternion
+	MatOri         []measurement.RotationMatrix
+	EulerOri       []measurement.EulerAngles
+	RotatedMagneto []measurement.Vector3D
 }
 
 // NewXSensLogParser is the constructor.
 func NewXSensLogParser(path string) *XSensLogParser {
 	x := XSensLogParser{
-		Path:               path,
-		Header:             make([]string, 0),
-		Accelero:           make([]measurement.Vector3D, 0),
-		Gyro:               make([]measurement.Vector3D, 0),
-		Magneto:            make([]measurement.Vector3D, 0),
-		EulerOri:           make([]measurement.EulerAngles, 0),
-		RotatedMagneto:     make([]measurement.Vector3D, 0),
-		IMUOri:             make([]measurement.EulerAngles, 0),
-		IMURotatedMagneto:  make([]measurement.Vector3D, 0),
-		WarmRotatedMagneto: make([]measurement.Vector3D, 0),
+		Path:           path,
+		Metadata:       *NewLogMetadata(),
+		Dropped:        *NewDropReport(),
+		Header:         make([]string, 0),
+		PacketCounter:  make([]int, 0),
+		Timestamps:     make([]float64, 0),
+		Accelero:       make([]measurement.Vector3D, 0),
+		Gyro:           make([]measurement.Vector3D, 0),
+		Magneto:        make([]measurement.Vector3D, 0),
+		VelInc:         make([]measurement.Vector3D, 0),
+		OriInc:         make([]measurement.Quaternion, 0),
+		QuatOri:        make([]measurement.Quaternion, 0),
+		MatOri:         make([]measurement.RotationMatrix, 0),
+		EulerOri:       make([]measurement.EulerAngles, 0),
+		RotatedMagneto: make([]measurement.Vector3D, 0),
 	}
 
 	return &x
@@ -45,10 +61,10 @@ func NewXSensLogParser(path string) *XSensLogParser {
 
 // GetFloatVector3D returns the float64 representation of 3 consecutive values from XSens log
 func GetFloatVector3D(chunks []string, startidx int) (measurement.Vector3D, error) {
-	result := measurement.Vector3D{X: 0.0, Y: 0.0, Z: 0.0}
+	result := measurement.Vector3D{X: 0.0, Y: 0.0, Z: 0.0, Frame: measurement.FrameSensor}
 
 	for i := 0; i < 3; i++ {
-		value, err := strconv.ParseFloat(chunks[startidx+i], 64)
+		value, err := parseFloatCell(chunks, startidx+i)
 		if err != nil {
 			return result, err
 		}
@@ -71,7 +87,7 @@ func GetFloatEuler(chunks []string, startidx int) (measurement.EulerAngles, erro
 	result := measurement.EulerAngles{Roll: 0.0, Pitch: 0.0, Yaw: 0.0}
 
 	for i := 0; i < 3; i++ {
-		value, err := strconv.ParseFloat(chunks[startidx+i], 64)
+		value, err := parseFloatCell(chunks, startidx+i)
 		if err != nil {
 			return result, err
 		}
@@ -89,12 +105,12 @@ func GetFloatEuler(chunks []string, startidx int) (measurement.EulerAngles, erro
 	return result, nil
 }
 
-// GetFloatQuaternion returns the float64 representation of 3 consecutive values from XSens log
+// GetFloatQuaternion returns the float64 representation of 4 consecutive values from XSens log
 func GetFloatQuaternion(chunks []string, startidx int) (measurement.Quaternion, error) {
 	result := measurement.Quaternion{Q0: 1.0, Q1: 0.0, Q2: 0.0, Q3: 0.0}
 
-	for i := 0; i < 3; i++ {
-		value, err := strconv.ParseFloat(chunks[startidx+i], 64)
+	for i := 0; i < 4; i++ {
+		value, err := parseFloatCell(chunks, startidx+i)
 		if err != nil {
 			return result, err
 		}
@@ -114,7 +130,26 @@ func GetFloatQuaternion(chunks []string, startidx int) (measurement.Quaternion,
 	return result, nil
 }
 
-// Parse is used to parse the given file.
+// GetFloatMatrix returns the float64 representation of 9 consecutive values from XSens log.
+// XSens logs the matrix column by column: Mat[1][1], Mat[2][1], Mat[3][1], Mat[1][2], ...
+func GetFloatMatrix(chunks []string, startidx int) (measurement.RotationMatrix, error) {
+	result := measurement.NewIdentityMatrix()
+
+	for i := 0; i < 9; i++ {
+		value, err := parseFloatCell(chunks, startidx+i)
+		if err != nil {
+			return result, err
+		}
+
+		result.M[i%3][i/3] = value
+	}
+
+	return result, nil
+}
+
+// Parse is used to parse the given file. It collects the samples read by SampleReader into the series of the
+// parser, use SampleReader directly for processing a log in constant memory. Malformed cells are reported as
+// ParseError, in Lenient mode the rows holding them are skipped and summarized in Dropped.
 func (x *XSensLogParser) Parse() (err error) {
 	// Opening the file
 	logfile, err := os.Open(x.Path)
@@ -129,106 +164,123 @@ func (x *XSensLogParser) Parse() (err error) {
 		}
 	}()
 
-	reader := csv.NewReader(logfile)
-	reader.Comma = '\t' // Use tab-delimited instead of comma
-
-	reader.FieldsPerRecord = -1
-
-	data, err := reader.ReadAll()
+	reader, err := x.newSampleReader(logfile)
 	if err != nil {
 		return err
 	}
 
-	isHeader := true
-	accStartIdx, gyrStartIdx, magStartIdx, eulerStartIdx := -1, -1, -1, -1
-
-	for _, chunks := range data {
-		if len(chunks) > 1 {
-			if isHeader {
-				x.Header = chunks
-
-				magStartIdx = indexOf("Mag_X", x.Header)
-				eulerStartIdx = indexOf("Roll", x.Header)
-				accStartIdx = indexOf("Acc_X", x.Header)
-				gyrStartIdx = indexOf("Gyr_X", x.Header)
-
-				if magStartIdx == -1 || eulerStartIdx == -1 || accStartIdx == -1 || gyrStartIdx == -1 {
-					err = errors.New("Required fields not found in file")
-				}
-
-				isHeader = false
-			} else {
-				if chunks[magStartIdx] != "" {
-					a, err := GetFloatVector3D(chunks, accStartIdx)
-					if err != nil {
-						return err
-					}
-					x.Accelero = append(x.Accelero, a)
-
-					g, err := GetFloatVector3D(chunks, gyrStartIdx)
-					if err != nil {
-						return err
-					}
-					x.Gyro = append(x.Gyro, g)
-
-					m, err := GetFloatVector3D(chunks, magStartIdx)
-					if err != nil {
-						return err
-					}
-					x.Magneto = append(x.Magneto, m)
-
-					e, err := GetFloatEuler(chunks, eulerStartIdx)
-					if err != nil {
-						return err
-					}
-					x.EulerOri = append(x.EulerOri, e)
-
-					rotated_m := m.GetRotatedEuler(e)
-					x.RotatedMagneto = append(x.RotatedMagneto, rotated_m)
-				}
-			}
+	// Rows skipped before a failure are reported as well
+	defer func() {
+		x.Dropped = reader.Dropped
+	}()
+
+	for {
+		s, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if reader.HasPacketCounter() {
+			x.PacketCounter = append(x.PacketCounter, s.PacketCounter)
+		}
+		if reader.HasTimestamps() {
+			x.Timestamps = append(x.Timestamps, s.Timestamp)
 		}
+		if reader.HasVelInc() {
+			x.VelInc = append(x.VelInc, s.VelInc)
+		}
+		if reader.HasOriInc() {
+			x.OriInc = append(x.OriInc, s.OriInc)
+		}
+		if reader.HasQuatOri() {
+			x.QuatOri = append(x.QuatOri, s.QuatOri)
+		}
+		if reader.HasMatOri() {
+			x.MatOri = append(x.MatOri, s.MatOri)
+		}
+		x.Accelero = append(x.Accelero, s.Accelero)
+		x.Gyro = append(x.Gyro, s.Gyro)
+		x.Magneto = append(x.Magneto, s.Magneto)
+		x.EulerOri = append(x.EulerOri, s.EulerOri)
+		x.RotatedMagneto = append(x.RotatedMagneto, s.RotatedMagneto)
 	}
 
 	return err
 }
 
-// CalculateIMUAngles uses software imu filter to calculate the euler angles
-func (x *XSensLogParser) CalculateIMUAngles() {
-	imufilter := imu.NewMadgwickAHRS(100.0, 2.0)
-
-	for idx := range x.Accelero {
-		imufilter.Update(x.Gyro[idx], x.Accelero[idx], x.Magneto[idx])
-		rotated_magneto := x.Magneto[idx].GetRotated(imufilter.Quaternion)
-		x.IMURotatedMagneto = append(x.IMURotatedMagneto, rotated_magneto)
-		x.IMUOri = append(x.IMUOri, imufilter.Quaternion.GetAsEuler())
+// newSampleReader reads the header of the log
+func (x *XSensLogParser) newSampleReader(r io.Reader) (*SampleReader, error) {
+	reader, err := NewSampleReader(r, x.Frame)
+	if err != nil {
+		return nil, err
 	}
-}
 
-func MinOf(vars ...int) int {
-	min := vars[0]
+	reader.File = x.Path
+	reader.Lenient = x.Lenient
+
+	x.Metadata = reader.Metadata
+	x.Header = reader.Header
+	x.Frame = reader.Frame
+
+	return reader, nil
+}
 
-	for _, i := range vars {
-		if min > i {
-			min = i
+// GetRecording returns the parsed log as a recording, the channels share the series of the parser. Logs without
+// timestamps are given the timestamps of the default sampling frequency.
+func (x *XSensLogParser) GetRecording() (*recording.Recording, error) {
+	r := recording.NewRecording(x.Path)
+	r.DeviceID = x.Metadata.DeviceID
+	r.Frame = x.Frame
+	r.Metadata = x.Metadata.GetFields()
+
+	r.Timestamps = x.Timestamps
+	if len(x.Timestamps) != len(x.Accelero) {
+		r.Timestamps = make([]float64, 0, len(x.Accelero))
+		for idx := range x.Accelero {
+			r.Timestamps = append(r.Timestamps, float64(idx)/DefaultSamplingFrequency)
 		}
 	}
 
-	return min
-}
+	chip := make([]measurement.Quaternion, 0, len(x.EulerOri))
+	for _, e := range x.EulerOri {
+		chip = append(chip, e.GetAsQuaternion())
+	}
 
-// CalculateRotMagnetoWithPrewarm uses software imu filter with additional prewarming to calculate the rotated magneto
-func (x *XSensLogParser) CalculateRotMagnetoWithPrewarm() {
-	imufilter := imu.NewMadgwickAHRS(100.0, 2.0)
+	for _, err := range []error{
+		r.AddVectors(recording.Accelero, x.Accelero),
+		r.AddVectors(recording.Gyro, x.Gyro),
+		r.AddVectors(recording.Magneto, x.Magneto),
+		r.AddVectors(recording.RotatedMagneto, x.RotatedMagneto),
+		r.AddOrientations(recording.ChipOrientation, chip),
+	} {
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	prewarmsize := MinOf(20, len(x.Accelero), len(x.Magneto), len(x.Gyro))
-	imufilter.ApplyPrewarm(x.Gyro[0:prewarmsize], x.Accelero[0:prewarmsize], x.Magneto[0:prewarmsize])
+	if len(x.QuatOri) > 0 {
+		err := r.AddOrientations(recording.ChipQuaternion, x.QuatOri)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	for idx := range x.Accelero {
-		imufilter.Update(x.Gyro[idx], x.Accelero[idx], x.Magneto[idx])
-		rotated_magneto := x.Magneto[idx].GetRotated(imufilter.Quaternion)
-		x.WarmRotatedMagneto = append(x.WarmRotatedMagneto, rotated_magneto)
+	if len(x.MatOri) > 0 {
+		matrix := make([]measurement.Quaternion, 0, len(x.MatOri))
+		for _, m := range x.MatOri {
+			matrix = append(matrix, m.GetAsQuaternion())
+		}
+
+		err := r.AddOrientations(recording.ChipMatrix, matrix)
+		if err != nil {
+			return nil, err
+		}
 	}
+
+	return r, nil
 }
 
 func indexOf(element string, data []string) int {
@@ -0,0 +1,140 @@
+// Package processing holds the stages deriving new channels from a recording: calibration, magnetic disturbance
+// detection, software orientation filters and heading
+package processing
+
+import (
+	"github.com/ptrngy/xsens_rotate/pkg/calibration"
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+	"github.com/ptrngy/xsens_rotate/pkg/recording"
+)
+
+// CalibrationStage corrects the inertial and magnetometer readings and turns readings and chip orientation from
+// sensor frame into body frame with the Alignment. Unset corrections are skipped.
+type CalibrationStage struct {
+	Magneto   *calibration.MagnetoCalibration
+	Accelero  *calibration.AcceleroCalibration
+	Gyro      *calibration.GyroCalibration
+	Alignment *measurement.Quaternion
+}
+
+// NewCalibrationStage is the constructor.
+func NewCalibrationStage() *CalibrationStage {
+	return &CalibrationStage{}
+}
+
+// ApplyProfile uses the corrections of the profile unless set already
+func (s *CalibrationStage) ApplyProfile(p *calibration.Profile) {
+	if s.Magneto == nil {
+		s.Magneto = p.Magneto
+	}
+	if s.Accelero == nil {
+		s.Accelero = p.Accelero
+	}
+	if s.Gyro == nil {
+		s.Gyro = p.Gyro
+	}
+	if s.Alignment == nil {
+		s.Alignment = p.Alignment
+	}
+}
+
+// alignVector rotates a sensor frame reading into the body frame
+func (s *CalibrationStage) alignVector(v measurement.Vector3D) measurement.Vector3D {
+	if s.Alignment == nil || v.IsEmpty() {
+		return v
+	}
+
+	aligned := s.Alignment.Rotate(v)
+	aligned.Frame = v.Frame
+
+	return aligned
+}
+
+// Apply returns the readings of a sample corrected and in body frame
+func (s *CalibrationStage) Apply(gyro, accelero, magneto measurement.Vector3D) (measurement.Vector3D, measurement.Vector3D, measurement.Vector3D) {
+	if s.Gyro != nil {
+		gyro = s.Gyro.Apply(gyro)
+	}
+
+	if s.Accelero != nil {
+		accelero = s.Accelero.Apply(accelero)
+	}
+
+	if s.Magneto != nil {
+		magneto = s.Magneto.Apply(magneto)
+	}
+
+	return s.alignVector(gyro), s.alignVector(accelero), s.alignVector(magneto)
+}
+
+// AlignOrientation turns a sensor orientation output of the chip into body orientation
+func (s *CalibrationStage) AlignOrientation(q measurement.Quaternion) measurement.Quaternion {
+	if s.Alignment == nil {
+		return q
+	}
+
+	return q.Multiply(s.Alignment.Conjugate())
+}
+
+// Process derives the CalibratedGyro, CalibratedAccelero, CalibratedMagneto and AlignedChipOrientation channels, and
+// AlignedChipQuaternion if the recording has the quaternion output of the chip
+func (s *CalibrationStage) Process(r *recording.Recording) (*recording.Recording, error) {
+	gyro, err := r.GetVectors(recording.Gyro)
+	if err != nil {
+		return nil, err
+	}
+
+	accelero, err := r.GetVectors(recording.Accelero)
+	if err != nil {
+		return nil, err
+	}
+
+	magneto, err := r.GetVectors(recording.Magneto)
+	if err != nil {
+		return nil, err
+	}
+
+	chip, err := r.GetOrientations(recording.ChipOrientation)
+	if err != nil {
+		return nil, err
+	}
+
+	calgyro := make([]measurement.Vector3D, 0, r.Len())
+	calaccelero := make([]measurement.Vector3D, 0, r.Len())
+	calmagneto := make([]measurement.Vector3D, 0, r.Len())
+	aligned := make([]measurement.Quaternion, 0, r.Len())
+
+	for idx := 0; idx < r.Len(); idx++ {
+		g, a, m := s.Apply(gyro[idx], accelero[idx], magneto[idx])
+		calgyro = append(calgyro, g)
+		calaccelero = append(calaccelero, a)
+		calmagneto = append(calmagneto, m)
+		aligned = append(aligned, s.AlignOrientation(chip[idx]))
+	}
+
+	result := r.Derive()
+	for _, err := range []error{
+		result.AddVectors(recording.CalibratedGyro, calgyro),
+		result.AddVectors(recording.CalibratedAccelero, calaccelero),
+		result.AddVectors(recording.CalibratedMagneto, calmagneto),
+		result.AddOrientations(recording.AlignedChipOrientation, aligned),
+	} {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if quat, ok := r.Orientations[recording.ChipQuaternion]; ok {
+		alignedquat := make([]measurement.Quaternion, 0, len(quat))
+		for _, q := range quat {
+			alignedquat = append(alignedquat, s.AlignOrientation(q))
+		}
+
+		err = result.AddOrientations(recording.AlignedChipQuaternion, alignedquat)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
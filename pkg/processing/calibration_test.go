@@ -0,0 +1,201 @@
+package processing
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/ptrngy/xsens_rotate/pkg/calibration"
+	"github.com/ptrngy/xsens_rotate/pkg/evaluation"
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+	"github.com/ptrngy/xsens_rotate/pkg/recording"
+)
+
+const testTolerance = 1e-9
+
+// getRecording returns a still recording of n samples at 100 Hz, the sensor turned 30 degree to the west
+func getRecording(n int) *recording.Recording {
+	r := recording.NewRecording("test")
+	r.DeviceID = "0123ABCD"
+	r.Frame = measurement.FrameNWU
+
+	gyro := make([]measurement.Vector3D, 0, n)
+	accelero := make([]measurement.Vector3D, 0, n)
+	magneto := make([]measurement.Vector3D, 0, n)
+	chip := make([]measurement.Quaternion, 0, n)
+
+	orientation := measurement.NewQuaternionFromAxisAngle(measurement.Vector3D{Z: 1.0}, math.Pi/6.0)
+	orientation.Frame = measurement.FrameNWU
+
+	for idx := 0; idx < n; idx++ {
+		r.Timestamps = append(r.Timestamps, float64(idx)/100.0)
+		gyro = append(gyro, measurement.Vector3D{X: 0.01, Y: -0.02, Z: 0.03, Frame: measurement.FrameSensor})
+		accelero = append(accelero, measurement.Vector3D{X: 0.0, Y: 0.0, Z: 9.81, Frame: measurement.FrameSensor})
+		magneto = append(magneto, measurement.Vector3D{X: 0.4, Y: 0.2, Z: -0.8, Frame: measurement.FrameSensor})
+		chip = append(chip, orientation)
+	}
+
+	for _, err := range []error{
+		r.AddVectors(recording.Gyro, gyro),
+		r.AddVectors(recording.Accelero, accelero),
+		r.AddVectors(recording.Magneto, magneto),
+		r.AddOrientations(recording.ChipOrientation, chip),
+	} {
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	return r
+}
+
+func assertVector(t *testing.T, name string, got, want measurement.Vector3D) {
+	t.Helper()
+
+	if math.Abs(got.X-want.X) > testTolerance || math.Abs(got.Y-want.Y) > testTolerance ||
+		math.Abs(got.Z-want.Z) > testTolerance || got.Frame != want.Frame {
+		t.Errorf("%s: got %v, want %v", name, got, want)
+	}
+}
+
+// getAlignment returns the sensor mounted upside down on the body, rotated 180 degree around X
+func getAlignment() *measurement.Quaternion {
+	a := measurement.NewQuaternionFromAxisAngle(measurement.Vector3D{X: 1.0}, math.Pi)
+	return &a
+}
+
+func TestCalibrationStage(t *testing.T) {
+	r := getRecording(3)
+
+	s := NewCalibrationStage()
+	s.Gyro = &calibration.GyroCalibration{Bias: measurement.Vector3D{X: 0.01, Y: -0.02, Z: 0.01}}
+	s.Alignment = getAlignment()
+
+	if err := recording.Process(r, s); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	// The bias is removed in sensor frame, then the readings are turned into the body frame
+	for idx := 0; idx < r.Len(); idx++ {
+		assertVector(t, "gyro", r.Vectors[recording.CalibratedGyro][idx], measurement.Vector3D{Z: -0.02, Frame: measurement.FrameSensor})
+		assertVector(t, "accelero", r.Vectors[recording.CalibratedAccelero][idx], measurement.Vector3D{Z: -9.81, Frame: measurement.FrameSensor})
+		assertVector(t, "magneto", r.Vectors[recording.CalibratedMagneto][idx], measurement.Vector3D{X: 0.4, Y: -0.2, Z: 0.8, Frame: measurement.FrameSensor})
+
+		// The body frame X axis still points 30 degree west, its Z axis down
+		aligned := r.Orientations[recording.AlignedChipOrientation][idx]
+		if aligned.Frame != measurement.FrameNWU {
+			t.Errorf("aligned orientation: got frame %s, want NWU", aligned.Frame)
+		}
+		assertVector(t, "body X", aligned.Rotate(measurement.Vector3D{X: 1.0}), measurement.Vector3D{X: math.Cos(math.Pi / 6.0), Y: math.Sin(math.Pi / 6.0), Frame: measurement.FrameNWU})
+		assertVector(t, "body Z", aligned.Rotate(measurement.Vector3D{Z: 1.0}), measurement.Vector3D{Z: -1.0, Frame: measurement.FrameNWU})
+	}
+
+	// The raw channels are kept
+	assertVector(t, "raw gyro", r.Vectors[recording.Gyro][0], measurement.Vector3D{X: 0.01, Y: -0.02, Z: 0.03, Frame: measurement.FrameSensor})
+	if _, ok := r.Orientations[recording.AlignedChipQuaternion]; ok {
+		t.Errorf("aligned quaternion derived without the quaternion output of the chip")
+	}
+
+	// Without corrections the readings pass through
+	r = getRecording(1)
+	if err := recording.Process(r, NewCalibrationStage()); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	assertVector(t, "uncorrected magneto", r.Vectors[recording.CalibratedMagneto][0], r.Vectors[recording.Magneto][0])
+	if r.Orientations[recording.AlignedChipOrientation][0] != r.Orientations[recording.ChipOrientation][0] {
+		t.Errorf("unaligned orientation was modified")
+	}
+
+	// A recording missing an input channel is not calibrated
+	r = recording.NewRecording("empty")
+	if err := recording.Process(r, NewCalibrationStage()); !errors.Is(err, recording.ErrNoChannel) {
+		t.Errorf("missing channel: got error %v, want %v", err, recording.ErrNoChannel)
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	store := calibration.NewProfileStore(t.TempDir())
+
+	p := calibration.NewProfile("0123ABCD")
+	p.Gyro = &calibration.GyroCalibration{Bias: measurement.Vector3D{X: 0.01, Y: -0.02, Z: 0.03}}
+	p.Alignment = getAlignment()
+	if err := store.Save(*p); err != nil {
+		t.Fatalf("unable to save profile: %s", err.Error())
+	}
+
+	// Corrections set on the stage are kept
+	s := NewCalibrationStage()
+	own := measurement.Quaternion{Q0: 1.0}
+	s.Alignment = &own
+	s.ApplyProfile(p)
+	if s.Alignment != &own || s.Gyro != p.Gyro {
+		t.Errorf("stage: got alignment %v, gyro %v", s.Alignment, s.Gyro)
+	}
+
+	// No store, no device ID or no profile of the device leave the recording as it is
+	for _, tt := range []struct {
+		name     string
+		store    *calibration.ProfileStore
+		deviceid string
+	}{
+		{"no store", nil, "0123ABCD"},
+		{"no device ID", store, ""},
+		{"no profile", store, "FFFFFFFF"},
+	} {
+		r := getRecording(2)
+		r.DeviceID = tt.deviceid
+
+		got, err := ApplyProfile(r, tt.store)
+		if got != nil || err != nil {
+			t.Errorf("%s: got %v, %v, want no profile", tt.name, got, err)
+		}
+		if len(r.ChannelNames()) != 4 {
+			t.Errorf("%s: got channels %v", tt.name, r.ChannelNames())
+		}
+	}
+
+	r := getRecording(2)
+	got, err := ApplyProfile(r, store)
+	if err != nil || got == nil || got.DeviceID != r.DeviceID {
+		t.Fatalf("got %v, %v, want the profile of %s", got, err, r.DeviceID)
+	}
+	assertVector(t, "gyro", r.Vectors[recording.CalibratedGyro][0], measurement.Vector3D{Frame: measurement.FrameSensor})
+	assertVector(t, "accelero", r.Vectors[recording.CalibratedAccelero][0], measurement.Vector3D{Z: -9.81, Frame: measurement.FrameSensor})
+}
+
+func TestEvaluateAgainstChip(t *testing.T) {
+	r := getRecording(200)
+
+	// The estimate matches the chip orientation in body frame
+	s := NewCalibrationStage()
+	s.Alignment = getAlignment()
+	if err := recording.Process(r, s); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	aligned := r.Orientations[recording.AlignedChipOrientation]
+	if err := r.AddOrientations(recording.IMUOrientation, append([]measurement.Quaternion(nil), aligned...)); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	report, err := EvaluateAgainstChip(r, recording.IMUOrientation, evaluation.DefaultConfig())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if report.AngleRMS > testTolerance || !report.Converged {
+		t.Errorf("against the aligned chip orientation: got RMS %f, converged %t", report.AngleRMS, report.Converged)
+	}
+
+	// Against the sensor orientation the estimate is off by the alignment
+	report, err = Evaluate(r, recording.ChipOrientation, recording.IMUOrientation, evaluation.DefaultConfig())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if math.Abs(report.AngleRMS-math.Pi) > 1e-6 {
+		t.Errorf("against the sensor orientation: got RMS %f, want %f", report.AngleRMS, math.Pi)
+	}
+
+	if _, err := EvaluateAgainstChip(r, recording.ChipQuaternion, evaluation.DefaultConfig()); !errors.Is(err, recording.ErrNoChannel) {
+		t.Errorf("missing estimate: got error %v, want %v", err, recording.ErrNoChannel)
+	}
+}
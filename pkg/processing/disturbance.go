@@ -0,0 +1,179 @@
+package processing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ptrngy/xsens_rotate/pkg/calibration"
+	"github.com/ptrngy/xsens_rotate/pkg/imu"
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+	"github.com/ptrngy/xsens_rotate/pkg/recording"
+)
+
+// emptyVector is fed into the filters in place of rejected magnetometer readings
+var emptyVector = measurement.Vector3D{X: 0.0, Y: 0.0, Z: 0.0, Frame: measurement.FrameSensor}
+
+// getMedian returns the median of the values, the values are sorted in place
+func getMedian(values []float64) float64 {
+	sort.Float64s(values)
+
+	return values[len(values)/2]
+}
+
+// hasReadings checks if any of the magnetometer readings is not empty
+func hasReadings(magneto []measurement.Vector3D) bool {
+	for _, m := range magneto {
+		if !m.IsEmpty() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DisturbanceStage flags the disturbed magnetometer readings with the Detector, or with one fitted to the
+// recording if it is not set. Flagged readings are left out of the fusion, the filters fall back to the
+// accelerometer and the gyroscope for them.
+type DisturbanceStage struct {
+	Detector  *imu.DisturbanceDetector
+	Reference *measurement.Vector3D
+}
+
+// NewDisturbanceStage is the constructor. The inclination of a fitted detector comes from the magnetic
+// reference if not nil.
+func NewDisturbanceStage(reference *measurement.Vector3D) *DisturbanceStage {
+	return &DisturbanceStage{Reference: reference}
+}
+
+// newDetector creates a detector for the readings: the expected field strength is the median magnitude of the
+// readings, the inclination comes from the magnetic reference if set, the median dip angle of the readings
+// otherwise
+func (s *DisturbanceStage) newDetector(accelero, magneto []measurement.Vector3D) (*imu.DisturbanceDetector, error) {
+	strengths := make([]float64, 0)
+	inclinations := make([]float64, 0)
+
+	for idx, m := range magneto {
+		if m.IsEmpty() {
+			continue
+		}
+
+		strengths = append(strengths, m.Norm())
+		inclinations = append(inclinations, imu.GetInclination(accelero[idx], m))
+	}
+
+	if len(strengths) == 0 {
+		return nil, errors.New("no magnetometer readings")
+	}
+
+	inclination := getMedian(inclinations)
+	if s.Reference != nil {
+		inclination = math.Asin(-s.Reference.Z / s.Reference.Norm())
+	}
+
+	return imu.NewDisturbanceDetector(getMedian(strengths), inclination), nil
+}
+
+// Process derives the MagnetoDisturbed, MagnetoStrength and MagnetoInclination channels. A detector fitted to the
+// recording is kept in Detector. Without magnetometer readings there is nothing to fit or flag, no channel is
+// derived and Detector is left nil.
+func (s *DisturbanceStage) Process(r *recording.Recording) (*recording.Recording, error) {
+	// Detection works on all readings, not on those left after a previous detection
+	_, accelero, magneto, err := getCalibratedInputs(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Detector == nil && !hasReadings(magneto) {
+		return r.Derive(), nil
+	}
+
+	if s.Detector == nil {
+		s.Detector, err = s.newDetector(accelero, magneto)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	disturbed := make([]bool, 0, len(magneto))
+	strengths := make([]float64, 0, len(magneto))
+	inclinations := make([]float64, 0, len(magneto))
+
+	for idx, m := range magneto {
+		if m.IsEmpty() {
+			strengths = append(strengths, math.NaN())
+			inclinations = append(inclinations, math.NaN())
+		} else {
+			strengths = append(strengths, m.Norm())
+			inclinations = append(inclinations, imu.GetInclination(accelero[idx], m))
+		}
+
+		disturbed = append(disturbed, s.Detector.IsDisturbed(accelero[idx], m))
+	}
+
+	result := r.Derive()
+	for _, err := range []error{
+		result.AddFlags(recording.MagnetoDisturbed, disturbed),
+		result.AddScalars(recording.MagnetoStrength, strengths),
+		result.AddScalars(recording.MagnetoInclination, inclinations),
+	} {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// GetDisturbedIntervals returns the sample ranges between the first and the last flagged reading of each
+// disturbance, readings missing between flagged ones do not split the range
+func GetDisturbedIntervals(r *recording.Recording) []calibration.Interval {
+	result := make([]calibration.Interval, 0)
+	current := calibration.Interval{Start: -1, End: -1}
+	magneto := r.Vectors[recording.Magneto]
+
+	for idx, disturbed := range r.Flags[recording.MagnetoDisturbed] {
+		switch {
+		case disturbed && current.Start == -1:
+			current = calibration.Interval{Start: idx, End: idx + 1}
+		case disturbed:
+			current.End = idx + 1
+		case current.Start != -1 && !magneto[idx].IsEmpty():
+			result = append(result, current)
+			current = calibration.Interval{Start: -1, End: -1}
+		}
+	}
+
+	if current.Start != -1 {
+		result = append(result, current)
+	}
+
+	return result
+}
+
+// ExportDisturbances writes the disturbed intervals as a tab-separated file: sample range, time range in
+// seconds and the number of flagged readings
+func ExportDisturbances(r *recording.Recording, path string) error {
+	var b strings.Builder
+	b.WriteString("StartSample\tEndSample\tStartTime\tEndTime\tReadings\n")
+
+	flags := r.Flags[recording.MagnetoDisturbed]
+	for _, interval := range GetDisturbedIntervals(r) {
+		readings := 0
+		for idx := interval.Start; idx < interval.End; idx++ {
+			if flags[idx] {
+				readings++
+			}
+		}
+
+		start := r.Timestamps[interval.Start]
+		end := r.Timestamps[interval.End-1]
+
+		fmt.Fprintf(&b, "%d\t%d\t%.4f\t%.4f\t%d\n", interval.Start, interval.End, start, end, readings)
+	}
+
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
@@ -0,0 +1,62 @@
+package processing
+
+import (
+	"math"
+
+	"github.com/ptrngy/xsens_rotate/pkg/evaluation"
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+	"github.com/ptrngy/xsens_rotate/pkg/recording"
+)
+
+// Evaluate compares an orientation channel of the recording, e.g. IMUOrientation, with a reference channel, e.g.
+// AlignedChipOrientation or ChipQuaternion
+func Evaluate(r *recording.Recording, reference, estimate string, cfg evaluation.Config) (*evaluation.Report, error) {
+	ref, err := r.GetOrientations(reference)
+	if err != nil {
+		return nil, err
+	}
+
+	est, err := r.GetOrientations(estimate)
+	if err != nil {
+		return nil, err
+	}
+
+	return evaluation.Evaluate(ref, est, r.Timestamps, cfg)
+}
+
+// EvaluateAgainstChip compares an orientation channel with the chip orientation, in body frame if the recording
+// has it
+func EvaluateAgainstChip(r *recording.Recording, estimate string, cfg evaluation.Config) (*evaluation.Report, error) {
+	reference := recording.ChipOrientation
+	if _, ok := r.Orientations[recording.AlignedChipOrientation]; ok {
+		reference = recording.AlignedChipOrientation
+	}
+
+	return Evaluate(r, reference, estimate, cfg)
+}
+
+// GetChipOrientationDeviation returns the largest angular distance in radians between the Euler, quaternion and
+// rotation matrix orientation outputs of the chip, for the outputs present in the recording
+func GetChipOrientationDeviation(r *recording.Recording) float64 {
+	deviation := 0.0
+	quat, hasQuat := r.Orientations[recording.ChipQuaternion]
+	matrix, hasMatrix := r.Orientations[recording.ChipMatrix]
+
+	for idx, e := range r.Orientations[recording.ChipOrientation] {
+		outputs := []measurement.Quaternion{e}
+		if hasQuat {
+			outputs = append(outputs, quat[idx])
+		}
+		if hasMatrix {
+			outputs = append(outputs, matrix[idx])
+		}
+
+		for i := 0; i < len(outputs); i++ {
+			for j := i + 1; j < len(outputs); j++ {
+				deviation = math.Max(deviation, outputs[i].AngularDistance(outputs[j]))
+			}
+		}
+	}
+
+	return deviation
+}
@@ -0,0 +1,226 @@
+package processing
+
+import (
+	"github.com/ptrngy/xsens_rotate/pkg/calibration"
+	"github.com/ptrngy/xsens_rotate/pkg/imu"
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+	"github.com/ptrngy/xsens_rotate/pkg/recording"
+)
+
+// DefaultFilter is the software orientation filter used unless another one is named.
+const DefaultFilter = "madgwick"
+
+// alignmentSearchTime is the length in seconds of the beginning of the log searched for a stationary window to
+// align the filter in
+const alignmentSearchTime = 10.0
+
+// getCalibratedInputs returns the calibrated readings if the recording has them, the raw ones otherwise
+func getCalibratedInputs(r *recording.Recording) ([]measurement.Vector3D, []measurement.Vector3D, []measurement.Vector3D, error) {
+	gyro, err := r.GetFirstVectors(recording.CalibratedGyro, recording.Gyro)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	accelero, err := r.GetFirstVectors(recording.CalibratedAccelero, recording.Accelero)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	magneto, err := r.GetFirstVectors(recording.CalibratedMagneto, recording.Magneto)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return gyro, accelero, magneto, nil
+}
+
+// getFusionInputs returns the readings as fed into the filter: calibrated if the recording has them, with the
+// magnetometer readings flagged as disturbed left empty
+func getFusionInputs(r *recording.Recording) ([]measurement.Vector3D, []measurement.Vector3D, []measurement.Vector3D, error) {
+	gyro, accelero, magneto, err := getCalibratedInputs(r)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	disturbed, ok := r.Flags[recording.MagnetoDisturbed]
+	if !ok {
+		return gyro, accelero, magneto, nil
+	}
+
+	rejected := make([]measurement.Vector3D, 0, len(magneto))
+	for idx, m := range magneto {
+		if disturbed[idx] {
+			m = emptyVector
+		}
+		rejected = append(rejected, m)
+	}
+
+	return gyro, accelero, rejected, nil
+}
+
+// getChipOrientation returns the chip orientation in body frame if the recording has it, in sensor frame otherwise
+func getChipOrientation(r *recording.Recording) ([]measurement.Quaternion, error) {
+	return r.GetFirstOrientations(recording.AlignedChipOrientation, recording.ChipOrientation)
+}
+
+func MinOf(vars ...int) int {
+	min := vars[0]
+
+	for _, i := range vars {
+		if min > i {
+			min = i
+		}
+	}
+
+	return min
+}
+
+// getAlignmentWindow returns the sample range the initial alignment is computed from: the first second of the
+// first stationary interval, or the first 20 samples if the log does not start with one
+func getAlignmentWindow(r *recording.Recording, gyro, accelero []measurement.Vector3D) (int, int) {
+	size := r.Len()
+	searched := MinOf(size, int(alignmentSearchTime*r.SamplingFrequency()))
+
+	still := calibration.DetectStill(accelero[:searched], gyro[:searched], r.SamplingFrequency(), calibration.DefaultStillnessConfig())
+	if len(still) == 0 {
+		return 0, MinOf(20, size)
+	}
+
+	return still[0].Start, MinOf(still[0].End, still[0].Start+int(r.SamplingFrequency()))
+}
+
+// Fusion runs a software orientation filter sample by sample, for FilterStage and for consumers streaming a log
+type Fusion struct {
+	Filter imu.OrientationFilter
+	Frame  measurement.Frame
+}
+
+// Update feeds the calibrated readings of a sample into the filter and returns the orientation, expressed in the
+// frame of the log if the latter is known
+func (f *Fusion) Update(gyro, accelero, magneto measurement.Vector3D, dt float64) measurement.Quaternion {
+	f.Filter.Update(gyro, accelero, magneto, dt)
+
+	// Filters normalizing with the fast inverse square root are only close to unit length
+	q := f.Filter.GetQuaternion()
+	q.Normalize()
+
+	if !f.Frame.IsNavigation() {
+		return q
+	}
+
+	result, err := q.InFrame(f.Frame)
+	if err != nil {
+		return q
+	}
+
+	return result
+}
+
+// FilterStage runs a software orientation filter over the recording. With Prewarm the filter starts from the
+// coarse alignment of a stationary window. Output channels with empty name are not derived.
+type FilterStage struct {
+	Name              string
+	Params            map[string]float64
+	MagneticReference *measurement.Vector3D
+	Prewarm           bool
+	Orientation       string
+	Sigma             string
+	RotatedMagneto    string
+}
+
+// NewFilterStage is the constructor, the stage derives the IMUOrientation, IMUOrientationSigma and
+// IMURotatedMagneto channels.
+func NewFilterStage(name string, params map[string]float64) *FilterStage {
+	s := FilterStage{
+		Name:           name,
+		Params:         params,
+		Orientation:    recording.IMUOrientation,
+		Sigma:          recording.IMUOrientationSigma,
+		RotatedMagneto: recording.IMURotatedMagneto,
+	}
+
+	return &s
+}
+
+// NewPrewarmFilterStage returns a prewarmed filter stage deriving the WarmRotatedMagneto channel
+func NewPrewarmFilterStage(name string, params map[string]float64) *FilterStage {
+	s := FilterStage{
+		Name:           name,
+		Params:         params,
+		Prewarm:        true,
+		RotatedMagneto: recording.WarmRotatedMagneto,
+	}
+
+	return &s
+}
+
+// NewFusion creates the filter of the stage, seeded with the magnetic reference if the filter can use it
+func (s *FilterStage) NewFusion(samplingfreq float64, frame measurement.Frame) (*Fusion, error) {
+	f, err := imu.NewFilter(s.Name, samplingfreq, s.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	if setter, ok := f.(imu.MagneticReferenceSetter); ok && s.MagneticReference != nil {
+		setter.SetMagneticReference(*s.MagneticReference)
+	}
+
+	return &Fusion{Filter: f, Frame: frame}, nil
+}
+
+// Process runs the filter over the fusion inputs of the recording
+func (s *FilterStage) Process(r *recording.Recording) (*recording.Recording, error) {
+	gyro, accelero, magneto, err := getFusionInputs(r)
+	if err != nil {
+		return nil, err
+	}
+
+	fusion, err := s.NewFusion(r.SamplingFrequency(), r.Frame)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Prewarm {
+		from, to := getAlignmentWindow(r, gyro, accelero)
+		fusion.Filter.ApplyPrewarm(gyro[from:to], accelero[from:to], magneto[from:to], r.GetDeltaTs(from, to))
+	}
+
+	orientations := make([]measurement.Quaternion, 0, r.Len())
+	sigmas := make([]measurement.EulerAngles, 0)
+	rotated := make([]measurement.Vector3D, 0, r.Len())
+
+	for idx := 0; idx < r.Len(); idx++ {
+		q := fusion.Update(gyro[idx], accelero[idx], magneto[idx], r.GetDeltaT(idx))
+		orientations = append(orientations, q)
+		rotated = append(rotated, magneto[idx].GetRotated(q))
+
+		// Filters tracking their uncertainty also provide the attitude standard deviation
+		if u, ok := fusion.Filter.(imu.UncertaintyEstimator); ok {
+			sigmas = append(sigmas, u.GetEulerSigma())
+		}
+	}
+
+	result := r.Derive()
+	if s.Orientation != "" {
+		err = result.AddOrientations(s.Orientation, orientations)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.Sigma != "" && len(sigmas) > 0 {
+		err = result.AddAngles(s.Sigma, sigmas)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.RotatedMagneto != "" {
+		err = result.AddVectors(s.RotatedMagneto, rotated)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
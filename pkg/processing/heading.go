@@ -0,0 +1,87 @@
+package processing
+
+import (
+	"math"
+
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+	"github.com/ptrngy/xsens_rotate/pkg/recording"
+)
+
+// HeadingStage derives the tilt compensated heading from the chip orientation and, if the recording has it, from
+// the software filter orientation. Adding the Declination (radians, east positive) turns magnetic heading into
+// true heading.
+type HeadingStage struct {
+	Declination float64
+}
+
+// NewHeadingStage is the constructor.
+func NewHeadingStage(declination float64) *HeadingStage {
+	return &HeadingStage{Declination: declination}
+}
+
+// getHeading returns the heading in radians from the magnetometer reading and the orientation, with the
+// declination applied. Samples without magnetometer reading have NaN heading.
+func (s *HeadingStage) getHeading(magneto measurement.Vector3D, o measurement.Quaternion) (float64, error) {
+	if magneto.IsEmpty() {
+		return math.NaN(), nil
+	}
+
+	heading, err := magneto.GetHeading(o)
+	if err != nil {
+		return 0.0, err
+	}
+
+	return math.Mod(heading+s.Declination+2.0*math.Pi, 2.0*math.Pi), nil
+}
+
+// getHeadings returns the heading series of an orientation series
+func (s *HeadingStage) getHeadings(magneto []measurement.Vector3D, orientations []measurement.Quaternion) ([]float64, error) {
+	result := make([]float64, 0, len(orientations))
+
+	for idx, o := range orientations {
+		heading, err := s.getHeading(magneto[idx], o)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, heading)
+	}
+
+	return result, nil
+}
+
+// Process derives the ChipHeading and the IMUHeading channels
+func (s *HeadingStage) Process(r *recording.Recording) (*recording.Recording, error) {
+	_, _, magneto, err := getFusionInputs(r)
+	if err != nil {
+		return nil, err
+	}
+
+	chip, err := getChipOrientation(r)
+	if err != nil {
+		return nil, err
+	}
+
+	result := r.Derive()
+
+	headings, err := s.getHeadings(magneto, chip)
+	if err != nil {
+		return nil, err
+	}
+	err = result.AddScalars(recording.ChipHeading, headings)
+	if err != nil {
+		return nil, err
+	}
+
+	if imuori, ok := r.Orientations[recording.IMUOrientation]; ok {
+		headings, err = s.getHeadings(magneto, imuori)
+		if err != nil {
+			return nil, err
+		}
+		err = result.AddScalars(recording.IMUHeading, headings)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,37 @@
+package processing
+
+import (
+	"errors"
+
+	"github.com/ptrngy/xsens_rotate/pkg/calibration"
+	"github.com/ptrngy/xsens_rotate/pkg/recording"
+)
+
+// LoadProfile returns the calibration profile of the device from the store. It returns nil without error if the
+// store is nil, the log does not name the device or the store has no profile for it.
+func LoadProfile(store *calibration.ProfileStore, deviceid string) (*calibration.Profile, error) {
+	if store == nil || deviceid == "" {
+		return nil, nil
+	}
+
+	profile, err := store.Load(deviceid)
+	if errors.Is(err, calibration.ErrNoProfile) {
+		return nil, nil
+	}
+
+	return profile, err
+}
+
+// ApplyProfile calibrates the recording with the profile of its device from the store and returns the profile,
+// the recording is left as it is if there is none
+func ApplyProfile(r *recording.Recording, store *calibration.ProfileStore) (*calibration.Profile, error) {
+	profile, err := LoadProfile(store, r.DeviceID)
+	if err != nil || profile == nil {
+		return profile, err
+	}
+
+	calibrator := NewCalibrationStage()
+	calibrator.ApplyProfile(profile)
+
+	return profile, recording.Process(r, calibrator)
+}
@@ -0,0 +1,58 @@
+package processing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+)
+
+// ReferenceDeviation compares rotated magnetometer readings with the expected direction of the earth field,
+// angles are in radians
+type ReferenceDeviation struct {
+	Inclination float64
+	AngleRMS    float64
+	AngleMax    float64
+	Samples     int
+}
+
+func (d ReferenceDeviation) String() string {
+	return fmt.Sprintf("inclination %.2f, deviation from reference RMS %.2f, max %.2f degree (%d samples)",
+		d.Inclination*180.0/math.Pi, d.AngleRMS*180.0/math.Pi, d.AngleMax*180.0/math.Pi, d.Samples)
+}
+
+// GetReferenceDeviation compares rotated magnetometer readings (e.g. the RotatedMagneto or WarmRotatedMagneto
+// channel) with the magnetic reference. Empty readings are skipped, readings of unknown frame give
+// measurement.ErrUnknownFrame.
+func GetReferenceDeviation(rotated []measurement.Vector3D, reference measurement.Vector3D) (ReferenceDeviation, error) {
+	result := ReferenceDeviation{}
+	reference.Scale(1.0 / reference.Norm())
+
+	for _, m := range rotated {
+		if m.IsEmpty() {
+			continue
+		}
+
+		nwu, err := m.InFrame(measurement.FrameNWU)
+		if err != nil {
+			return result, err
+		}
+		nwu.Scale(1.0 / nwu.Norm())
+
+		angle := math.Acos(math.Max(-1.0, math.Min(1.0, nwu.Dot(reference))))
+		result.AngleRMS += angle * angle
+		result.AngleMax = math.Max(result.AngleMax, angle)
+		result.Inclination += math.Asin(-nwu.Z)
+		result.Samples++
+	}
+
+	if result.Samples == 0 {
+		return result, errors.New("no magnetometer readings")
+	}
+
+	result.AngleRMS = math.Sqrt(result.AngleRMS / float64(result.Samples))
+	result.Inclination /= float64(result.Samples)
+
+	return result, nil
+}
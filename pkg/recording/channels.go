@@ -0,0 +1,30 @@
+package recording
+
+// Channels produced by the parsers. Orientations are body to world, in the frame of the log.
+const (
+	Accelero        = "accelero"
+	Gyro            = "gyro"
+	Magneto         = "magneto"
+	ChipOrientation = "chip"
+	ChipQuaternion  = "chip_quat"
+	ChipMatrix      = "chip_matrix"
+	RotatedMagneto  = "rotmagneto"
+)
+
+// Channels derived by the processing stages
+const (
+	CalibratedAccelero     = "accelero_cal"
+	CalibratedGyro         = "gyro_cal"
+	CalibratedMagneto      = "magneto_cal"
+	AlignedChipOrientation = "chip_aligned"
+	AlignedChipQuaternion  = "chip_quat_aligned"
+	MagnetoDisturbed       = "magneto_disturbed"
+	MagnetoStrength        = "magneto_strength"
+	MagnetoInclination     = "magneto_inclination"
+	IMUOrientation         = "imu"
+	IMUOrientationSigma    = "imu_sigma"
+	IMURotatedMagneto      = "imu_rotmagneto"
+	WarmRotatedMagneto     = "prewarm_rotmagneto"
+	ChipHeading            = "chip_heading"
+	IMUHeading             = "imu_heading"
+)
@@ -0,0 +1,321 @@
+// Package recording holds the data model shared by the parsers, the processing stages and the outputs: a log as
+// a set of timestamped channels with its metadata
+package recording
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+)
+
+// DefaultSamplingFrequency is assumed when a log carries no timestamps.
+const DefaultSamplingFrequency = 100.0
+
+// ErrNoChannel is returned when a recording has no channel of the requested name
+var ErrNoChannel = errors.New("no such channel")
+
+// Recording is a log as named channels sharing the timestamps. Channels are grouped by type, a channel holds one
+// value per timestamp. Sparse series (e.g. magnetometer) store missing readings as empty vectors.
+type Recording struct {
+	Source       string
+	DeviceID     string
+	Frame        measurement.Frame
+	Metadata     map[string]string
+	Timestamps   []float64
+	Vectors      map[string][]measurement.Vector3D
+	Orientations map[string][]measurement.Quaternion
+	Angles       map[string][]measurement.EulerAngles
+	Scalars      map[string][]float64
+	Flags        map[string][]bool
+}
+
+// NewRecording is the constructor.
+func NewRecording(source string) *Recording {
+	r := Recording{
+		Source:       source,
+		Metadata:     make(map[string]string),
+		Timestamps:   make([]float64, 0),
+		Vectors:      make(map[string][]measurement.Vector3D),
+		Orientations: make(map[string][]measurement.Quaternion),
+		Angles:       make(map[string][]measurement.EulerAngles),
+		Scalars:      make(map[string][]float64),
+		Flags:        make(map[string][]bool),
+	}
+
+	return &r
+}
+
+// Derive returns an empty recording with the metadata and the timestamps of r, for the channels derived from it
+func (r *Recording) Derive() *Recording {
+	d := NewRecording(r.Source)
+	d.DeviceID = r.DeviceID
+	d.Frame = r.Frame
+	d.Metadata = r.Metadata
+	d.Timestamps = r.Timestamps
+
+	return d
+}
+
+// AssumeFrame states the navigation frame of a recording whose log does not name one. The channels holding
+// values of unknown frame are replaced by copies in the given frame, sensor frame readings are left as they are.
+func (r *Recording) AssumeFrame(f measurement.Frame) {
+	if r.Frame != measurement.FrameUnknown {
+		return
+	}
+	r.Frame = f
+
+	for name, values := range r.Vectors {
+		var assumed []measurement.Vector3D
+		for idx, v := range values {
+			if v.Frame != measurement.FrameUnknown {
+				continue
+			}
+			if assumed == nil {
+				assumed = append([]measurement.Vector3D(nil), values...)
+			}
+			assumed[idx].Frame = f
+		}
+		if assumed != nil {
+			r.Vectors[name] = assumed
+		}
+	}
+
+	for name, values := range r.Orientations {
+		assumed := make([]measurement.Quaternion, 0, len(values))
+		for _, q := range values {
+			if q.Frame == measurement.FrameUnknown {
+				q.Frame = f
+			}
+			assumed = append(assumed, q)
+		}
+		r.Orientations[name] = assumed
+	}
+
+	for name, values := range r.Angles {
+		assumed := make([]measurement.EulerAngles, 0, len(values))
+		for _, e := range values {
+			if e.Frame == measurement.FrameUnknown {
+				e.Frame = f
+			}
+			assumed = append(assumed, e)
+		}
+		r.Angles[name] = assumed
+	}
+}
+
+// Len returns the number of samples
+func (r *Recording) Len() int {
+	return len(r.Timestamps)
+}
+
+// checkLength checks that a channel has one value per timestamp
+func (r *Recording) checkLength(name string, length int) error {
+	if length != r.Len() {
+		return fmt.Errorf("channel %s has %d samples, recording has %d", name, length, r.Len())
+	}
+
+	return nil
+}
+
+// AddVectors adds or replaces a vector channel
+func (r *Recording) AddVectors(name string, values []measurement.Vector3D) error {
+	err := r.checkLength(name, len(values))
+	if err != nil {
+		return err
+	}
+	r.Vectors[name] = values
+
+	return nil
+}
+
+// AddOrientations adds or replaces an orientation channel
+func (r *Recording) AddOrientations(name string, values []measurement.Quaternion) error {
+	err := r.checkLength(name, len(values))
+	if err != nil {
+		return err
+	}
+	r.Orientations[name] = values
+
+	return nil
+}
+
+// AddAngles adds or replaces an angle channel
+func (r *Recording) AddAngles(name string, values []measurement.EulerAngles) error {
+	err := r.checkLength(name, len(values))
+	if err != nil {
+		return err
+	}
+	r.Angles[name] = values
+
+	return nil
+}
+
+// AddScalars adds or replaces a scalar channel
+func (r *Recording) AddScalars(name string, values []float64) error {
+	err := r.checkLength(name, len(values))
+	if err != nil {
+		return err
+	}
+	r.Scalars[name] = values
+
+	return nil
+}
+
+// AddFlags adds or replaces a flag channel
+func (r *Recording) AddFlags(name string, values []bool) error {
+	err := r.checkLength(name, len(values))
+	if err != nil {
+		return err
+	}
+	r.Flags[name] = values
+
+	return nil
+}
+
+// GetVectors returns the vector channel of the given name
+func (r *Recording) GetVectors(name string) ([]measurement.Vector3D, error) {
+	values, ok := r.Vectors[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoChannel, name)
+	}
+
+	return values, nil
+}
+
+// GetOrientations returns the orientation channel of the given name
+func (r *Recording) GetOrientations(name string) ([]measurement.Quaternion, error) {
+	values, ok := r.Orientations[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoChannel, name)
+	}
+
+	return values, nil
+}
+
+// GetFirstVectors returns the first of the given vector channels the recording has, e.g. the calibrated readings
+// falling back to the raw ones
+func (r *Recording) GetFirstVectors(names ...string) ([]measurement.Vector3D, error) {
+	for _, name := range names {
+		if values, ok := r.Vectors[name]; ok {
+			return values, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %v", ErrNoChannel, names)
+}
+
+// GetFirstOrientations returns the first of the given orientation channels the recording has
+func (r *Recording) GetFirstOrientations(names ...string) ([]measurement.Quaternion, error) {
+	for _, name := range names {
+		if values, ok := r.Orientations[name]; ok {
+			return values, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %v", ErrNoChannel, names)
+}
+
+// Merge adds the channels of a recording derived from r, channels of the same name are replaced
+func (r *Recording) Merge(d *Recording) error {
+	if d.Len() != r.Len() {
+		return fmt.Errorf("derived recording has %d samples, recording has %d", d.Len(), r.Len())
+	}
+
+	for name, values := range d.Vectors {
+		r.Vectors[name] = values
+	}
+	for name, values := range d.Orientations {
+		r.Orientations[name] = values
+	}
+	for name, values := range d.Angles {
+		r.Angles[name] = values
+	}
+	for name, values := range d.Scalars {
+		r.Scalars[name] = values
+	}
+	for name, values := range d.Flags {
+		r.Flags[name] = values
+	}
+
+	return nil
+}
+
+// ChannelNames returns the names of all channels, sorted
+func (r *Recording) ChannelNames() []string {
+	result := make([]string, 0)
+
+	for name := range r.Vectors {
+		result = append(result, name)
+	}
+	for name := range r.Orientations {
+		result = append(result, name)
+	}
+	for name := range r.Angles {
+		result = append(result, name)
+	}
+	for name := range r.Scalars {
+		result = append(result, name)
+	}
+	for name := range r.Flags {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// CountReadings returns the number of non empty readings of a vector channel
+func (r *Recording) CountReadings(name string) int {
+	count := 0
+
+	for _, v := range r.Vectors[name] {
+		if !v.IsEmpty() {
+			count++
+		}
+	}
+
+	return count
+}
+
+// SamplingFrequency returns the nominal sampling frequency based on the median sample interval.
+func (r *Recording) SamplingFrequency() float64 {
+	if len(r.Timestamps) < 2 {
+		return DefaultSamplingFrequency
+	}
+
+	intervals := make([]float64, 0, len(r.Timestamps)-1)
+	for i := 1; i < len(r.Timestamps); i++ {
+		intervals = append(intervals, r.Timestamps[i]-r.Timestamps[i-1])
+	}
+	sort.Float64s(intervals)
+
+	median := intervals[len(intervals)/2]
+	if median <= 0 {
+		return DefaultSamplingFrequency
+	}
+
+	return 1.0 / median
+}
+
+// GetDeltaT returns the time elapsed between the given sample and the previous one in seconds.
+// The first sample falls back to the nominal sampling period.
+func (r *Recording) GetDeltaT(idx int) float64 {
+	if idx <= 0 || idx >= len(r.Timestamps) {
+		return 1.0 / r.SamplingFrequency()
+	}
+
+	return r.Timestamps[idx] - r.Timestamps[idx-1]
+}
+
+// GetDeltaTs returns the sample intervals of the given range
+func (r *Recording) GetDeltaTs(from, to int) []float64 {
+	result := make([]float64, 0, to-from)
+
+	for idx := from; idx < to; idx++ {
+		result = append(result, r.GetDeltaT(idx))
+	}
+
+	return result
+}
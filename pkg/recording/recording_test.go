@@ -0,0 +1,188 @@
+package recording
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+)
+
+// getRecording returns a recording of n samples at 100 Hz
+func getRecording(n int) *Recording {
+	r := NewRecording("test")
+	for idx := 0; idx < n; idx++ {
+		r.Timestamps = append(r.Timestamps, float64(idx)/100.0)
+	}
+
+	return r
+}
+
+func TestAssumeFrame(t *testing.T) {
+	r := getRecording(2)
+	raw := []measurement.Vector3D{{Z: 9.81, Frame: measurement.FrameSensor}, {Z: 9.81, Frame: measurement.FrameSensor}}
+	rotated := []measurement.Vector3D{{X: 20.0, Z: -40.0}, {X: 20.0, Z: -40.0}}
+	chip := []measurement.Quaternion{{Q0: 1.0}, {Q0: 1.0}}
+
+	for _, err := range []error{
+		r.AddVectors(Accelero, raw),
+		r.AddVectors(RotatedMagneto, rotated),
+		r.AddOrientations(ChipOrientation, chip),
+		r.AddAngles("euler", []measurement.EulerAngles{{}, {}}),
+	} {
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+
+	r.AssumeFrame(measurement.FrameNWU)
+
+	if r.Frame != measurement.FrameNWU {
+		t.Errorf("frame: got %s, want NWU", r.Frame)
+	}
+
+	for idx := 0; idx < r.Len(); idx++ {
+		if r.Vectors[Accelero][idx].Frame != measurement.FrameSensor {
+			t.Errorf("sensor frame reading %d retagged as %s", idx, r.Vectors[Accelero][idx].Frame)
+		}
+		if r.Vectors[RotatedMagneto][idx].Frame != measurement.FrameNWU ||
+			r.Orientations[ChipOrientation][idx].Frame != measurement.FrameNWU || r.Angles["euler"][idx].Frame != measurement.FrameNWU {
+			t.Errorf("sample %d is not in the assumed frame", idx)
+		}
+	}
+
+	// The channels are replaced, the series given to the recording are left as they are
+	if rotated[0].Frame != measurement.FrameUnknown || chip[0].Frame != measurement.FrameUnknown {
+		t.Errorf("the added series were modified")
+	}
+
+	// A known frame is not overridden
+	r.AssumeFrame(measurement.FrameENU)
+	if r.Frame != measurement.FrameNWU || r.Orientations[ChipOrientation][0].Frame != measurement.FrameNWU {
+		t.Errorf("known frame was overridden")
+	}
+}
+
+func TestAddChannelLength(t *testing.T) {
+	r := getRecording(3)
+
+	// A series not matching the timestamps is rejected and not added
+	if err := r.AddVectors(Accelero, make([]measurement.Vector3D, 2)); err == nil {
+		t.Errorf("short vector series added")
+	}
+	if err := r.AddOrientations(ChipOrientation, make([]measurement.Quaternion, 4)); err == nil {
+		t.Errorf("long orientation series added")
+	}
+	if err := r.AddScalars(ChipHeading, nil); err == nil {
+		t.Errorf("empty scalar series added")
+	}
+	if len(r.ChannelNames()) != 0 {
+		t.Errorf("rejected channels were added: %v", r.ChannelNames())
+	}
+
+	if err := r.AddVectors(Accelero, make([]measurement.Vector3D, 3)); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if err := r.AddOrientations(ChipOrientation, make([]measurement.Quaternion, 3)); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+
+	// A derived recording of other length is not merged
+	if err := r.Merge(getRecording(2)); err == nil {
+		t.Errorf("derived recording of other length merged")
+	}
+}
+
+func TestGetFirstOrientations(t *testing.T) {
+	r := getRecording(1)
+	chip := []measurement.Quaternion{{Q0: 1.0}}
+	aligned := []measurement.Quaternion{{Q0: 0.0, Q3: 1.0}}
+
+	if err := r.AddOrientations(ChipOrientation, chip); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	// Without the aligned channel the chip orientation is returned
+	got, err := r.GetFirstOrientations(AlignedChipOrientation, ChipOrientation)
+	if err != nil || got[0] != chip[0] {
+		t.Errorf("fallback: got %v, %v, want %v", got, err, chip)
+	}
+
+	if err := r.AddOrientations(AlignedChipOrientation, aligned); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	got, err = r.GetFirstOrientations(AlignedChipOrientation, ChipOrientation)
+	if err != nil || got[0] != aligned[0] {
+		t.Errorf("first channel: got %v, %v, want %v", got, err, aligned)
+	}
+
+	_, err = r.GetFirstOrientations(IMUOrientation, ChipQuaternion)
+	if !errors.Is(err, ErrNoChannel) {
+		t.Errorf("no channel: got error %v, want %v", err, ErrNoChannel)
+	}
+}
+
+// scaleStage derives the output channel as the input channel times the factor, and logs its name when run
+type scaleStage struct {
+	name, input, output string
+	factor              float64
+	log                 *[]string
+}
+
+func (s scaleStage) Process(r *Recording) (*Recording, error) {
+	*s.log = append(*s.log, s.name)
+
+	input, ok := r.Scalars[s.input]
+	if !ok {
+		return nil, ErrNoChannel
+	}
+
+	values := make([]float64, 0, len(input))
+	for _, v := range input {
+		values = append(values, v*s.factor)
+	}
+
+	d := r.Derive()
+	return d, d.AddScalars(s.output, values)
+}
+
+func TestProcess(t *testing.T) {
+	r := getRecording(2)
+	if err := r.AddScalars("a", []float64{1.0, 2.0}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	// Every stage reads the channel merged by the one before
+	log := make([]string, 0)
+	err := Process(r,
+		scaleStage{name: "first", input: "a", output: "b", factor: 2.0, log: &log},
+		scaleStage{name: "second", input: "b", output: "c", factor: 3.0, log: &log},
+		scaleStage{name: "third", input: "c", output: "a", factor: 0.5, log: &log},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(log, []string{"first", "second", "third"}) {
+		t.Errorf("stages ran as %v", log)
+	}
+
+	// A channel derived again is replaced
+	want := map[string][]float64{"a": {3.0, 6.0}, "b": {2.0, 4.0}, "c": {6.0, 12.0}}
+	if !reflect.DeepEqual(r.Scalars, want) {
+		t.Errorf("channels: got %v, want %v", r.Scalars, want)
+	}
+
+	// A failing stage stops the processing
+	log = log[:0]
+	err = Process(r,
+		scaleStage{name: "missing", input: "x", output: "y", factor: 1.0, log: &log},
+		scaleStage{name: "next", input: "a", output: "d", factor: 1.0, log: &log},
+	)
+	if !errors.Is(err, ErrNoChannel) || !reflect.DeepEqual(log, []string{"missing"}) {
+		t.Errorf("failing stage: got error %v after %v", err, log)
+	}
+	if _, ok := r.Scalars["d"]; ok {
+		t.Errorf("stage after the failing one ran")
+	}
+}
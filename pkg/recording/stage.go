@@ -0,0 +1,25 @@
+package recording
+
+// Stage is a processing step, it returns the channels it derives from the recording without modifying the latter.
+// The derived recording is created with Derive.
+type Stage interface {
+	Process(r *Recording) (*Recording, error)
+}
+
+// Process runs the stages in order, the channels derived by a stage are merged into the recording before the
+// next stage runs
+func Process(r *Recording, stages ...Stage) error {
+	for _, stage := range stages {
+		derived, err := stage.Process(r)
+		if err != nil {
+			return err
+		}
+
+		err = r.Merge(derived)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,293 @@
+// Package tuning searches the parameter space of a filter for the parameters minimising an error objective
+package tuning
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Dimension is a parameter searched between Min and Max. Grid search takes Steps evenly spaced values,
+// Nelder-Mead starts from the middle of the range.
+type Dimension struct {
+	Name  string
+	Min   float64
+	Max   float64
+	Steps int
+}
+
+// Objective returns the errors of a parameter set, e.g. one per log, the search minimises their mean
+type Objective func(params map[string]float64) ([]float64, error)
+
+// Candidate is an evaluated parameter set
+type Candidate struct {
+	Params map[string]float64
+	Errors []float64
+	Score  float64
+	Err    error
+}
+
+// ParseSpace parses a parameter space given as "name=min:max:steps,name=min:max:steps". Steps may be omitted
+// and default to 5, a single value fixes the parameter.
+func ParseSpace(s string) ([]Dimension, error) {
+	result := make([]Dimension, 0)
+
+	for _, chunk := range strings.Split(s, ",") {
+		if strings.TrimSpace(chunk) == "" {
+			continue
+		}
+
+		kv := strings.SplitN(chunk, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid parameter range: %q", chunk)
+		}
+
+		values := strings.Split(kv[1], ":")
+		if len(values) > 3 {
+			return nil, fmt.Errorf("invalid parameter range: %q", chunk)
+		}
+
+		d := Dimension{Name: strings.TrimSpace(kv[0]), Steps: 5}
+		bounds := make([]float64, 0, 2)
+		for i, v := range values {
+			if i == 2 {
+				steps, err := strconv.Atoi(strings.TrimSpace(v))
+				if err != nil || steps < 1 {
+					return nil, fmt.Errorf("invalid number of steps of %s: %q", d.Name, v)
+				}
+				d.Steps = steps
+				continue
+			}
+
+			value, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid bound of %s: %w", d.Name, err)
+			}
+			bounds = append(bounds, value)
+		}
+
+		d.Min, d.Max = bounds[0], bounds[0]
+		if len(bounds) == 2 {
+			d.Min, d.Max = math.Min(bounds[0], bounds[1]), math.Max(bounds[0], bounds[1])
+		}
+		if d.Min == d.Max {
+			d.Steps = 1
+		}
+
+		result = append(result, d)
+	}
+
+	if len(result) == 0 {
+		return nil, errors.New("empty parameter space")
+	}
+
+	return result, nil
+}
+
+// getValue returns the value of the given grid step
+func (d Dimension) getValue(step int) float64 {
+	if d.Steps <= 1 {
+		return (d.Min + d.Max) / 2.0
+	}
+
+	return d.Min + (d.Max-d.Min)*float64(step)/float64(d.Steps-1)
+}
+
+// clamp returns the value limited to the range of the dimension
+func (d Dimension) clamp(value float64) float64 {
+	return math.Max(d.Min, math.Min(d.Max, value))
+}
+
+// toParams returns the parameter set of a point of the space
+func toParams(space []Dimension, point []float64) map[string]float64 {
+	params := make(map[string]float64, len(space))
+	for i, d := range space {
+		params[d.Name] = d.clamp(point[i])
+	}
+
+	return params
+}
+
+// evaluateAll evaluates the parameter sets on the given number of goroutines, results keep the input order
+func evaluateAll(params []map[string]float64, objective Objective, workers int) []Candidate {
+	if workers < 1 {
+		workers = 1
+	}
+
+	result := make([]Candidate, len(params))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for idx := range jobs {
+				c := Candidate{Params: params[idx], Score: math.Inf(1)}
+				c.Errors, c.Err = objective(params[idx])
+				if c.Err == nil && len(c.Errors) > 0 {
+					c.Score = 0.0
+					for _, e := range c.Errors {
+						c.Score += e / float64(len(c.Errors))
+					}
+				}
+				result[idx] = c
+			}
+		}()
+	}
+
+	for idx := range params {
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+
+	return result
+}
+
+// sortCandidates orders the candidates by score, failed ones last
+func sortCandidates(candidates []Candidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score < candidates[j].Score
+	})
+}
+
+// GridSearch evaluates every point of the grid spanned by the dimensions in parallel and returns the candidates
+// ordered by score
+func GridSearch(space []Dimension, objective Objective, workers int) []Candidate {
+	points := [][]float64{{}}
+	for _, d := range space {
+		next := make([][]float64, 0, len(points)*d.Steps)
+		for _, p := range points {
+			for step := 0; step < d.Steps; step++ {
+				point := append(append([]float64{}, p...), d.getValue(step))
+				next = append(next, point)
+			}
+		}
+		points = next
+	}
+
+	params := make([]map[string]float64, 0, len(points))
+	for _, p := range points {
+		params = append(params, toParams(space, p))
+	}
+
+	result := evaluateAll(params, objective, workers)
+	sortCandidates(result)
+
+	return result
+}
+
+// NelderMead minimises the objective with the downhill simplex method within the bounds of the dimensions,
+// starting from the middle of the ranges. The initial simplex and shrink steps are evaluated in parallel.
+// All evaluated candidates are returned ordered by score.
+func NelderMead(space []Dimension, objective Objective, workers, iterations int) []Candidate {
+	n := len(space)
+	evaluated := make([]Candidate, 0)
+
+	type vertex struct {
+		point []float64
+		score float64
+	}
+
+	evaluate := func(points [][]float64) []vertex {
+		params := make([]map[string]float64, 0, len(points))
+		for _, p := range points {
+			for i, d := range space {
+				p[i] = d.clamp(p[i])
+			}
+			params = append(params, toParams(space, p))
+		}
+
+		candidates := evaluateAll(params, objective, workers)
+		evaluated = append(evaluated, candidates...)
+
+		result := make([]vertex, 0, len(points))
+		for i, c := range candidates {
+			result = append(result, vertex{point: points[i], score: c.Score})
+		}
+
+		return result
+	}
+
+	// Initial simplex: the middle of the ranges and a step of a quarter range along each dimension
+	points := make([][]float64, 0, n+1)
+	start := make([]float64, n)
+	for i, d := range space {
+		start[i] = (d.Min + d.Max) / 2.0
+	}
+	points = append(points, start)
+	for i, d := range space {
+		p := append([]float64{}, start...)
+		p[i] += (d.Max - d.Min) / 4.0
+		points = append(points, p)
+	}
+	simplex := evaluate(points)
+
+	for iteration := 0; iteration < iterations; iteration++ {
+		sort.SliceStable(simplex, func(i, j int) bool {
+			return simplex[i].score < simplex[j].score
+		})
+
+		best, worst := simplex[0], simplex[n]
+		if math.Abs(worst.score-best.score) < 1e-9*math.Max(1.0, math.Abs(best.score)) {
+			break
+		}
+
+		centroid := make([]float64, n)
+		for _, v := range simplex[:n] {
+			for i := range centroid {
+				centroid[i] += v.point[i] / float64(n)
+			}
+		}
+
+		along := func(factor float64) []float64 {
+			p := make([]float64, n)
+			for i := range p {
+				p[i] = centroid[i] + factor*(worst.point[i]-centroid[i])
+			}
+			return p
+		}
+
+		reflected := evaluate([][]float64{along(-1.0)})[0]
+		switch {
+		case reflected.score < best.score:
+			expanded := evaluate([][]float64{along(-2.0)})[0]
+			if expanded.score < reflected.score {
+				simplex[n] = expanded
+			} else {
+				simplex[n] = reflected
+			}
+			continue
+		case reflected.score < simplex[n-1].score:
+			simplex[n] = reflected
+			continue
+		}
+
+		contracted := evaluate([][]float64{along(0.5)})[0]
+		if contracted.score < worst.score {
+			simplex[n] = contracted
+			continue
+		}
+
+		// Shrink towards the best vertex
+		shrunk := make([][]float64, 0, n)
+		for _, v := range simplex[1:] {
+			p := make([]float64, n)
+			for i := range p {
+				p[i] = best.point[i] + 0.5*(v.point[i]-best.point[i])
+			}
+			shrunk = append(shrunk, p)
+		}
+		simplex = append([]vertex{best}, evaluate(shrunk)...)
+	}
+
+	sortCandidates(evaluated)
+
+	return evaluated
+}
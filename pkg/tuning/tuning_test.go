@@ -0,0 +1,172 @@
+package tuning
+
+import (
+	"errors"
+	"math"
+	"sync/atomic"
+	"testing"
+)
+
+// getQuadratic returns a convex objective with its minimum at the given parameters, counting its evaluations
+func getQuadratic(minimum map[string]float64, calls *int64) Objective {
+	return func(params map[string]float64) ([]float64, error) {
+		atomic.AddInt64(calls, 1)
+
+		e := 0.0
+		for name, value := range minimum {
+			e += (params[name] - value) * (params[name] - value)
+		}
+
+		// One error per log, the search minimises the mean
+		return []float64{e, e + 1.0}, nil
+	}
+}
+
+// assertInside fails the test if a candidate is outside of the space
+func assertInside(t *testing.T, space []Dimension, candidates []Candidate) {
+	t.Helper()
+
+	for _, c := range candidates {
+		for _, d := range space {
+			if v := c.Params[d.Name]; v < d.Min || v > d.Max {
+				t.Errorf("candidate %s=%f is outside of [%f, %f]", d.Name, v, d.Min, d.Max)
+			}
+		}
+	}
+}
+
+func TestGridSearch(t *testing.T) {
+	space, err := ParseSpace("beta=0:2:11,kp=0.5:1.5:3")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	var calls int64
+	candidates := GridSearch(space, getQuadratic(map[string]float64{"beta": 0.8, "kp": 1.0}, &calls), 4)
+
+	if len(candidates) != 33 || calls != 33 {
+		t.Errorf("got %d candidates in %d evaluations, want 33", len(candidates), calls)
+	}
+	assertInside(t, space, candidates)
+
+	best := candidates[0]
+	if math.Abs(best.Params["beta"]-0.8) > 1e-9 || math.Abs(best.Params["kp"]-1.0) > 1e-9 {
+		t.Errorf("best: got %v, want beta=0.8, kp=1", best.Params)
+	}
+	if math.Abs(best.Score-0.5) > 1e-9 {
+		t.Errorf("best score: got %f, want the mean error 0.5", best.Score)
+	}
+
+	for i := 1; i < len(candidates); i++ {
+		if candidates[i].Score < candidates[i-1].Score {
+			t.Fatalf("candidates are not ordered by score at %d", i)
+		}
+	}
+}
+
+func TestNelderMead(t *testing.T) {
+	tests := []struct {
+		name    string
+		space   string
+		minimum map[string]float64
+		want    map[string]float64
+	}{
+		{
+			name:    "inside",
+			space:   "beta=0:2,kp=0:3",
+			minimum: map[string]float64{"beta": 0.7, "kp": 1.3},
+			want:    map[string]float64{"beta": 0.7, "kp": 1.3},
+		},
+		{
+			// The unconstrained minimum is out of range, the search stops at the bound
+			name:    "bounded",
+			space:   "beta=0:2,kp=0:3",
+			minimum: map[string]float64{"beta": 3.5, "kp": 1.3},
+			want:    map[string]float64{"beta": 2.0, "kp": 1.3},
+		},
+		{
+			name:    "fixed parameter",
+			space:   "beta=0:2,kp=0.4",
+			minimum: map[string]float64{"beta": 1.6, "kp": 1.0},
+			want:    map[string]float64{"beta": 1.6, "kp": 0.4},
+		},
+	}
+
+	for _, tt := range tests {
+		space, err := ParseSpace(tt.space)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.name, err.Error())
+		}
+
+		var calls int64
+		candidates := NelderMead(space, getQuadratic(tt.minimum, &calls), 2, 200)
+		if int64(len(candidates)) != calls {
+			t.Errorf("%s: got %d candidates in %d evaluations", tt.name, len(candidates), calls)
+		}
+		assertInside(t, space, candidates)
+
+		for name, want := range tt.want {
+			if got := candidates[0].Params[name]; math.Abs(got-want) > 1e-3 {
+				t.Errorf("%s: best %s: got %f, want %f", tt.name, name, got, want)
+			}
+		}
+	}
+}
+
+func TestFailedCandidates(t *testing.T) {
+	space, err := ParseSpace("beta=0:2:5")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	// A parameter set the filter rejects is ranked last
+	objective := func(params map[string]float64) ([]float64, error) {
+		if params["beta"] == 0.0 {
+			return nil, errors.New("filter parameter beta must be positive")
+		}
+		return []float64{params["beta"]}, nil
+	}
+
+	candidates := GridSearch(space, objective, 1)
+	last := candidates[len(candidates)-1]
+	if last.Err == nil || !math.IsInf(last.Score, 1) || last.Params["beta"] != 0.0 {
+		t.Errorf("last candidate: got %v, score %f, error %v", last.Params, last.Score, last.Err)
+	}
+	if candidates[0].Params["beta"] != 0.5 {
+		t.Errorf("best: got %v, want beta=0.5", candidates[0].Params)
+	}
+}
+
+func TestParseSpace(t *testing.T) {
+	tests := []struct {
+		s     string
+		want  []Dimension
+		valid bool
+	}{
+		{"beta=0.05:2:8", []Dimension{{Name: "beta", Min: 0.05, Max: 2.0, Steps: 8}}, true},
+		{"kp=2:1", []Dimension{{Name: "kp", Min: 1.0, Max: 2.0, Steps: 5}}, true},
+		{"kp=1, ki=0:0.1:2", []Dimension{{Name: "kp", Min: 1.0, Max: 1.0, Steps: 1}, {Name: "ki", Min: 0.0, Max: 0.1, Steps: 2}}, true},
+		{"beta", nil, false},
+		{"beta=0:1:0", nil, false},
+		{"beta=a:1", nil, false},
+		{"", nil, false},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseSpace(tt.s)
+		if (err == nil) != tt.valid {
+			t.Errorf("%q: got error %v, want valid %t", tt.s, err, tt.valid)
+			continue
+		}
+
+		if len(got) != len(tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.s, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%q: got %v, want %v", tt.s, got[i], tt.want[i])
+			}
+		}
+	}
+}
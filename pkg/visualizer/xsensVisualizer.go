@@ -1,23 +1,46 @@
 package visualizer
 
 import (
+	"math"
+
 	"github.com/Arafatk/glot"
 	"github.com/ptrngy/xsens_rotate/pkg/measurement"
-	"github.com/ptrngy/xsens_rotate/pkg/parser"
+	"github.com/ptrngy/xsens_rotate/pkg/recording"
 )
 
+// XSensVisualizer plots the channels of a recording, plots of missing channels are skipped
 type XSensVisualizer struct {
-	Parser parser.XSensLogParser
+	Recording *recording.Recording
 }
 
-func NewXSensVisualizer(parser parser.XSensLogParser) *XSensVisualizer {
+func NewXSensVisualizer(r *recording.Recording) *XSensVisualizer {
 	x := XSensVisualizer{
-		Parser: parser,
+		Recording: r,
 	}
 
 	return &x
 }
 
+// getAngles returns the first of the given orientation channels the recording has as Euler angles
+func (x XSensVisualizer) getAngles(names ...string) ([]measurement.EulerAngles, bool) {
+	orientations, err := x.Recording.GetFirstOrientations(names...)
+	if err != nil {
+		return nil, false
+	}
+
+	result := make([]measurement.EulerAngles, 0, len(orientations))
+	for _, q := range orientations {
+		result = append(result, q.GetAsEuler())
+	}
+
+	return result, true
+}
+
+// getChipAngles returns the chip orientation as Euler angles, in body frame if the recording has it
+func (x XSensVisualizer) getChipAngles() ([]measurement.EulerAngles, bool) {
+	return x.getAngles(recording.AlignedChipOrientation, recording.ChipOrientation)
+}
+
 func getVector3DAsPointGroup(slice []measurement.Vector3D) ([][]float64, [][]float64, [][]float64) {
 	xresult := [][]float64{}
 	yresult := [][]float64{}
@@ -29,6 +52,11 @@ func getVector3DAsPointGroup(slice []measurement.Vector3D) ([][]float64, [][]flo
 	zvalues := make([]float64, 0)
 
 	for i, v := range slice {
+		// Sparse series (e.g. magnetometer) store missing readings as empty vectors
+		if v.IsEmpty() {
+			continue
+		}
+
 		indexes = append(indexes, float64(i))
 		xvalues = append(xvalues, v.X)
 		yvalues = append(yvalues, v.Y)
@@ -120,16 +148,167 @@ func plotAngles(slice []measurement.EulerAngles, name string) {
 	plot.SavePlot("output/" + name + ".png")
 }
 
+// getAngleBoundsAsPointGroup returns the given angle series shifted by +/- factor * sigma
+func getAngleBoundsAsPointGroup(angles, sigmas [][]float64, factor float64) ([][]float64, [][]float64) {
+	upper := [][]float64{angles[0], make([]float64, 0)}
+	lower := [][]float64{angles[0], make([]float64, 0)}
+
+	for i, v := range angles[1] {
+		upper[1] = append(upper[1], v+factor*sigmas[1][i])
+		lower[1] = append(lower[1], v-factor*sigmas[1][i])
+	}
+
+	return upper, lower
+}
+
+func plotAngleWithBounds(chip, imu, sigma [][]float64, name string) {
+	dimensions := 2
+	persist := false
+	debug := false
+	plot, _ := glot.NewPlot(dimensions, persist, debug)
+	style := "lines"
+	upper, lower := getAngleBoundsAsPointGroup(imu, sigma, 3.0)
+	plot.AddPointGroup("Chip", style, chip)
+	plot.AddPointGroup("Filter", style, imu)
+	plot.AddPointGroup("Filter +3 sigma", style, upper)
+	plot.AddPointGroup("Filter -3 sigma", style, lower)
+	plot.SetTitle(name + " with 3 sigma bounds")
+	plot.SetXLabel("Sample")
+	plot.SetYLabel("Degree")
+	plot.SavePlot("output/" + name + "sigma.png")
+}
+
+// plotVectorChannels plots each vector channel the recording has into the output file of the same index
+func (x XSensVisualizer) plotVectorChannels(names, files []string) {
+	for i, name := range names {
+		if v, ok := x.Recording.Vectors[name]; ok {
+			plotVector3D(v, files[i])
+		}
+	}
+}
+
 func (x XSensVisualizer) PlotBasics() {
-	plotVector3D(x.Parser.Accelero, "accelero")
-	plotVector3D(x.Parser.Gyro, "gyro")
-	plotVector3D(x.Parser.Magneto, "magneto")
-	plotVector3D(x.Parser.RotatedMagneto, "rotmagneto")
-	plotAngles(x.Parser.EulerOri, "fromchip")
+	x.plotVectorChannels([]string{recording.Accelero, recording.Gyro, recording.Magneto, recording.RotatedMagneto},
+		[]string{"accelero", "gyro", "magneto", "rotmagneto"})
+
+	if chip, ok := x.getChipAngles(); ok {
+		plotAngles(chip, "fromchip")
+	}
 }
 
 func (x XSensVisualizer) PlotIMURotated() {
-	plotAngles(x.Parser.IMUOri, "imuangles")
-	plotVector3D(x.Parser.IMURotatedMagneto, "imurotmagneto")
-	plotVector3D(x.Parser.WarmRotatedMagneto, "prewarmmagneto")
+	if imu, ok := x.getAngles(recording.IMUOrientation); ok {
+		plotAngles(imu, "imuangles")
+	}
+
+	x.plotVectorChannels([]string{recording.IMURotatedMagneto, recording.WarmRotatedMagneto},
+		[]string{"imurotmagneto", "prewarmmagneto"})
+}
+
+// PlotIMUUncertainty plots the software filter angles with their 3 sigma bounds next to the chip angles,
+// if the filter provides its uncertainty
+func (x XSensVisualizer) PlotIMUUncertainty() {
+	sigma, hasSigma := x.Recording.Angles[recording.IMUOrientationSigma]
+	chip, hasChip := x.getChipAngles()
+	imu, hasIMU := x.getAngles(recording.IMUOrientation)
+	if !hasSigma || !hasChip || !hasIMU {
+		return
+	}
+
+	chipRoll, chipPitch, chipYaw := getEulerSliceAsPointGroup(chip)
+	imuRoll, imuPitch, imuYaw := getEulerSliceAsPointGroup(imu)
+	sigmaRoll, sigmaPitch, sigmaYaw := getEulerSliceAsPointGroup(sigma)
+
+	plotAngleWithBounds(chipRoll, imuRoll, sigmaRoll, "roll")
+	plotAngleWithBounds(chipPitch, imuPitch, sigmaPitch, "pitch")
+	plotAngleWithBounds(chipYaw, imuYaw, sigmaYaw, "yaw")
+}
+
+// getSeriesAsPointGroup returns the samples of a scalar series, NaN values are skipped
+func getSeriesAsPointGroup(slice []float64) [][]float64 {
+	indexes := make([]float64, 0)
+	values := make([]float64, 0)
+
+	for i, v := range slice {
+		if math.IsNaN(v) {
+			continue
+		}
+
+		indexes = append(indexes, float64(i))
+		values = append(values, v)
+	}
+
+	return [][]float64{indexes, values}
+}
+
+// PlotHeading plots the tilt compensated heading next to the yaw of the chip, the latter expressed as azimuth
+func (x XSensVisualizer) PlotHeading() {
+	heading, hasHeading := x.Recording.Scalars[recording.ChipHeading]
+	chip, err := x.Recording.GetFirstOrientations(recording.AlignedChipOrientation, recording.ChipOrientation)
+	if !hasHeading || err != nil {
+		return
+	}
+
+	yaw := make([]float64, 0, len(chip))
+	for _, q := range chip {
+		azimuth, err := q.GetAzimuth()
+		if err != nil {
+			azimuth = math.NaN()
+		}
+		yaw = append(yaw, azimuth)
+	}
+
+	dimensions := 2
+	persist := false
+	debug := false
+	plot, _ := glot.NewPlot(dimensions, persist, debug)
+	style := "lines"
+	plot.AddPointGroup("Chip yaw", style, getSeriesAsPointGroup(yaw))
+	plot.AddPointGroup("Heading from chip tilt", style, getSeriesAsPointGroup(heading))
+	if imuheading, ok := x.Recording.Scalars[recording.IMUHeading]; ok {
+		plot.AddPointGroup("Heading from filter tilt", style, getSeriesAsPointGroup(imuheading))
+	}
+	plot.SetTitle("Heading Plot")
+	plot.SetXLabel("Sample")
+	plot.SetYLabel("Azimuth")
+	plot.SavePlot("output/heading.png")
+}
+
+// getFlaggedAsPointGroup returns the samples of a scalar series where the flag is set
+func getFlaggedAsPointGroup(slice []float64, flags []bool) [][]float64 {
+	flagged := make([]float64, 0, len(slice))
+
+	for i, v := range slice {
+		if i < len(flags) && flags[i] {
+			flagged = append(flagged, v)
+		} else {
+			flagged = append(flagged, math.NaN())
+		}
+	}
+
+	return getSeriesAsPointGroup(flagged)
+}
+
+func plotFlagged(slice []float64, flags []bool, name, title, label string) {
+	dimensions := 2
+	persist := false
+	debug := false
+	plot, _ := glot.NewPlot(dimensions, persist, debug)
+	plot.AddPointGroup(title, "lines", getSeriesAsPointGroup(slice))
+	plot.AddPointGroup("Disturbed", "points", getFlaggedAsPointGroup(slice, flags))
+	plot.SetTitle(title + " Plot")
+	plot.SetXLabel("Sample")
+	plot.SetYLabel(label)
+	plot.SavePlot("output/" + name + ".png")
+}
+
+// PlotDisturbance plots the magnetometer field strength and dip angle with the readings flagged as disturbed
+func (x XSensVisualizer) PlotDisturbance() {
+	disturbed, ok := x.Recording.Flags[recording.MagnetoDisturbed]
+	if !ok {
+		return
+	}
+
+	plotFlagged(x.Recording.Scalars[recording.MagnetoStrength], disturbed, "magstrength", "Field strength", "M")
+	plotFlagged(x.Recording.Scalars[recording.MagnetoInclination], disturbed, "maginclination", "Inclination", "Radian")
 }
@@ -0,0 +1,363 @@
+// Package wmm evaluates the World Magnetic Model offline, from the coefficient files embedded in the binary
+package wmm
+
+import (
+	"bufio"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ptrngy/xsens_rotate/pkg/measurement"
+)
+
+// coefficientFiles holds the WMM releases in the WMM.COF format published by NOAA, one file per release named
+// after its epoch
+//
+//go:embed *.COF
+var coefficientFiles embed.FS
+
+// validity is the number of years a model is valid for after its epoch
+const validity = 5.0
+
+// Reference radius of the spherical harmonic expansion and the WGS84 ellipsoid, in km
+const (
+	referenceRadius = 6371.2
+	wgs84A          = 6378.137
+	wgs84F          = 1.0 / 298.257223563
+)
+
+// ErrOutOfValidity is returned when the model is evaluated for a date outside of its validity period
+var ErrOutOfValidity = errors.New("date is outside of the validity period of the magnetic model")
+
+// Model holds the Gauss coefficients (nT) and their secular variation (nT / year) of a WMM release
+type Model struct {
+	Name   string
+	Epoch  float64
+	Degree int
+	g, h   [][]float64
+	gd, hd [][]float64
+}
+
+// Field is the main geomagnetic field at a location. X, Y and Z are the north, east and down components in nT,
+// angles are in radians, declination is east positive and inclination is down positive.
+type Field struct {
+	X           float64
+	Y           float64
+	Z           float64
+	Horizontal  float64
+	Intensity   float64
+	Declination float64
+	Inclination float64
+}
+
+// getEmbeddedModels returns the embedded models, oldest first
+func getEmbeddedModels() ([]*Model, error) {
+	names, err := coefficientFiles.ReadDir(".")
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*Model, 0, len(names))
+	for _, name := range names {
+		f, err := coefficientFiles.Open(name.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		model, err := ParseModel(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name.Name(), err)
+		}
+		result = append(result, model)
+	}
+
+	if len(result) == 0 {
+		return nil, errors.New("no embedded magnetic model")
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Epoch < result[j].Epoch
+	})
+
+	return result, nil
+}
+
+// NewModel returns the newest embedded model
+func NewModel() (*Model, error) {
+	models, err := getEmbeddedModels()
+	if err != nil {
+		return nil, err
+	}
+
+	return models[len(models)-1], nil
+}
+
+// NewModelFor returns the embedded model valid at the given date. Outside of the validity of all of them the
+// newest one is returned, its Evaluate extrapolates and returns ErrOutOfValidity.
+func NewModelFor(t time.Time) (*Model, error) {
+	models, err := getEmbeddedModels()
+	if err != nil {
+		return nil, err
+	}
+
+	for idx := len(models) - 1; idx >= 0; idx-- {
+		if models[idx].IsValid(t) {
+			return models[idx], nil
+		}
+	}
+
+	return models[len(models)-1], nil
+}
+
+// LoadModel reads a coefficient file in the WMM.COF format published by NOAA, e.g. a newer release
+func LoadModel(path string) (*Model, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseModel(f)
+}
+
+// ParseModel reads coefficients in the WMM.COF format: a header line with the epoch and the model name, then
+// lines of n, m, g, h, g dot, h dot, closed by a line of 9s
+func ParseModel(r io.Reader) (*Model, error) {
+	scanner := bufio.NewScanner(r)
+
+	if !scanner.Scan() {
+		return nil, errors.New("empty coefficient file")
+	}
+	header := strings.Fields(scanner.Text())
+	if len(header) < 2 {
+		return nil, fmt.Errorf("invalid coefficient file header: %q", scanner.Text())
+	}
+
+	epoch, err := strconv.ParseFloat(header[0], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid model epoch: %s", err.Error())
+	}
+
+	type coefficient struct {
+		n, m   int
+		values [4]float64
+	}
+	coefficients := make([]coefficient, 0)
+	degree := 0
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if strings.HasPrefix(fields[0], "9999") {
+			break
+		}
+		if len(fields) < 6 {
+			return nil, fmt.Errorf("invalid coefficient line: %q", scanner.Text())
+		}
+
+		c := coefficient{}
+		c.n, err = strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		c.m, err = strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		if c.n < 1 || c.m < 0 || c.m > c.n {
+			return nil, fmt.Errorf("invalid coefficient degree and order: %d %d", c.n, c.m)
+		}
+
+		for k := 0; k < 4; k++ {
+			c.values[k], err = strconv.ParseFloat(fields[k+2], 64)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		coefficients = append(coefficients, c)
+		if c.n > degree {
+			degree = c.n
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if degree == 0 {
+		return nil, errors.New("no coefficients in file")
+	}
+
+	model := Model{
+		Name:   header[1],
+		Epoch:  epoch,
+		Degree: degree,
+		g:      newTriangle(degree),
+		h:      newTriangle(degree),
+		gd:     newTriangle(degree),
+		hd:     newTriangle(degree),
+	}
+
+	for _, c := range coefficients {
+		model.g[c.n][c.m] = c.values[0]
+		model.h[c.n][c.m] = c.values[1]
+		model.gd[c.n][c.m] = c.values[2]
+		model.hd[c.n][c.m] = c.values[3]
+	}
+
+	return &model, nil
+}
+
+// newTriangle allocates a coefficient table indexed by degree and order
+func newTriangle(degree int) [][]float64 {
+	result := make([][]float64, degree+1)
+	for n := range result {
+		result[n] = make([]float64, n+1)
+	}
+
+	return result
+}
+
+// IsValid checks if the date is within the five years following the model epoch
+func (m Model) IsValid(t time.Time) bool {
+	dt := DecimalYear(t) - m.Epoch
+	return dt >= 0.0 && dt < validity
+}
+
+// DecimalYear returns the date as year with fraction, e.g. 2021.5 in early July 2021
+func DecimalYear(t time.Time) float64 {
+	t = t.UTC()
+	start := time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(1, 0, 0)
+
+	return float64(t.Year()) + t.Sub(start).Seconds()/end.Sub(start).Seconds()
+}
+
+// getLegendre returns the Schmidt semi-normalised associated Legendre functions of cos(theta) and their
+// derivatives by theta, with theta the geocentric colatitude
+func getLegendre(degree int, theta float64) ([][]float64, [][]float64) {
+	p := newTriangle(degree)
+	dp := newTriangle(degree)
+	x, s := math.Cos(theta), math.Sin(theta)
+
+	// Unnormalised functions without Condon-Shortley phase, the recursions avoid dividing by sin(theta)
+	p[0][0] = 1.0
+	for m := 0; m <= degree; m++ {
+		if m > 0 {
+			p[m][m] = float64(2*m-1) * s * p[m-1][m-1]
+			dp[m][m] = float64(2*m-1) * (s*dp[m-1][m-1] + x*p[m-1][m-1])
+		}
+
+		for n := m + 1; n <= degree; n++ {
+			p[n][m] = float64(2*n-1) * x * p[n-1][m]
+			dp[n][m] = float64(2*n-1) * (x*dp[n-1][m] - s*p[n-1][m])
+			if n-2 >= m {
+				p[n][m] -= float64(n+m-1) * p[n-2][m]
+				dp[n][m] -= float64(n+m-1) * dp[n-2][m]
+			}
+			p[n][m] /= float64(n - m)
+			dp[n][m] /= float64(n - m)
+		}
+	}
+
+	// Schmidt factor sqrt((2 - delta(m, 0)) * (n - m)! / (n + m)!)
+	for n := 1; n <= degree; n++ {
+		for m := 0; m <= n; m++ {
+			factor := 1.0
+			for k := n - m + 1; k <= n+m; k++ {
+				factor /= float64(k)
+			}
+			if m > 0 {
+				factor *= 2.0
+			}
+			factor = math.Sqrt(factor)
+
+			p[n][m] *= factor
+			dp[n][m] *= factor
+		}
+	}
+
+	return p, dp
+}
+
+// Evaluate returns the main field at the given geodetic latitude and longitude (degrees), height above the WGS84
+// ellipsoid (km) and date. ErrOutOfValidity is returned with the extrapolated field outside of the five years
+// following the model epoch.
+func (m Model) Evaluate(latitude, longitude, height float64, t time.Time) (Field, error) {
+	year := DecimalYear(t)
+	dt := year - m.Epoch
+
+	// Geodetic to geocentric spherical coordinates
+	phi := latitude * math.Pi / 180.0
+	lambda := longitude * math.Pi / 180.0
+	e2 := wgs84F * (2.0 - wgs84F)
+	rc := wgs84A / math.Sqrt(1.0-e2*math.Pow(math.Sin(phi), 2))
+	px := (rc + height) * math.Cos(phi)
+	pz := (rc*(1.0-e2) + height) * math.Sin(phi)
+	r := math.Sqrt(px*px + pz*pz)
+	phic := math.Asin(pz / r)
+
+	p, dp := getLegendre(m.Degree, math.Pi/2.0-phic)
+
+	// The east component divides by cos(latitude), keep it finite at the poles
+	cosphic := math.Max(math.Cos(phic), 1e-10)
+
+	var x, y, z float64
+	for n := 1; n <= m.Degree; n++ {
+		ratio := math.Pow(referenceRadius/r, float64(n+2))
+
+		for k := 0; k <= n; k++ {
+			g := m.g[n][k] + dt*m.gd[n][k]
+			h := m.h[n][k] + dt*m.hd[n][k]
+			cos, sin := math.Cos(float64(k)*lambda), math.Sin(float64(k)*lambda)
+
+			x += ratio * (g*cos + h*sin) * dp[n][k]
+			y += ratio * float64(k) * (g*sin - h*cos) * p[n][k] / cosphic
+			z -= float64(n+1) * ratio * (g*cos + h*sin) * p[n][k]
+		}
+	}
+
+	// Geocentric to geodetic components
+	psi := phic - phi
+	f := Field{
+		X: x*math.Cos(psi) - z*math.Sin(psi),
+		Y: y,
+		Z: x*math.Sin(psi) + z*math.Cos(psi),
+	}
+	f.Horizontal = math.Hypot(f.X, f.Y)
+	f.Intensity = math.Hypot(f.Horizontal, f.Z)
+	f.Declination = math.Atan2(f.Y, f.X)
+	f.Inclination = math.Atan2(f.Z, f.Horizontal)
+
+	if !m.IsValid(t) {
+		return f, ErrOutOfValidity
+	}
+
+	return f, nil
+}
+
+// GetMagneticReference returns the unit field direction in the NWU frame of magnetic north, the frame of the
+// software filters
+func (f Field) GetMagneticReference() measurement.Vector3D {
+	return measurement.Vector3D{
+		X:     math.Cos(f.Inclination),
+		Y:     0.0,
+		Z:     -math.Sin(f.Inclination),
+		Frame: measurement.FrameNWU,
+	}
+}
+
+func (f Field) String() string {
+	return fmt.Sprintf("Expected magnetic field: declination %.2f, inclination %.2f degree, intensity %.1f nT\n"+
+		"North: %.1f, east: %.1f, down: %.1f nT\n",
+		f.Declination*180.0/math.Pi, f.Inclination*180.0/math.Pi, f.Intensity, f.X, f.Y, f.Z)
+}
@@ -0,0 +1,109 @@
+package wmm
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// getDate returns the date of the decimal year, e.g. 2022.5 is noon of July 2
+func getDate(year float64) time.Time {
+	start := time.Date(int(year), time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(1, 0, 0)
+
+	return start.Add(time.Duration((year - math.Floor(year)) * float64(end.Sub(start))))
+}
+
+func TestEvaluate(t *testing.T) {
+	// Test values published by NOAA with WMM-2020, rounded to 0.1 nT and 0.01 degree
+	tests := []struct {
+		year, height, latitude, longitude float64
+		x, y, z, intensity                float64
+		inclination, declination          float64
+	}{
+		{2020.0, 0.0, 80.0, 0.0, 6570.4, -146.3, 54606.0, 55000.1, 83.14, -1.28},
+		{2020.0, 0.0, 0.0, 120.0, 39624.3, 109.9, -10932.5, 41104.9, -15.42, 0.16},
+		{2020.0, 0.0, -80.0, 240.0, 5940.6, 15772.1, -52480.8, 55120.6, -72.20, 69.36},
+		{2020.0, 100.0, 80.0, 0.0, 6261.8, -185.5, 52429.1, 52802.0, 83.19, -1.70},
+		{2022.5, 0.0, 80.0, 0.0, 6529.9, 1.1, 54713.4, 55101.7, 83.19, 0.01},
+	}
+
+	for _, tt := range tests {
+		date := getDate(tt.year)
+		m, err := NewModelFor(date)
+		if err != nil {
+			t.Fatalf("unable to load model: %s", err.Error())
+		}
+
+		f, err := m.Evaluate(tt.latitude, tt.longitude, tt.height, date)
+		if err != nil {
+			t.Errorf("%.1f %.0f km %.0f %.0f: unexpected error: %s", tt.year, tt.height, tt.latitude, tt.longitude, err.Error())
+			continue
+		}
+
+		for _, c := range []struct {
+			name      string
+			got, want float64
+			tolerance float64
+		}{
+			{"X", f.X, tt.x, 0.05},
+			{"Y", f.Y, tt.y, 0.05},
+			{"Z", f.Z, tt.z, 0.05},
+			{"F", f.Intensity, tt.intensity, 0.05},
+			{"I", f.Inclination * 180.0 / math.Pi, tt.inclination, 0.005},
+			{"D", f.Declination * 180.0 / math.Pi, tt.declination, 0.005},
+		} {
+			if math.Abs(c.got-c.want) > c.tolerance {
+				t.Errorf("%.1f %.0f km %.0f %.0f: %s is %.3f, want %.2f", tt.year, tt.height, tt.latitude, tt.longitude,
+					c.name, c.got, c.want)
+			}
+		}
+	}
+}
+
+func TestOutOfValidity(t *testing.T) {
+	m, err := NewModelFor(getDate(2020.0))
+	if err != nil {
+		t.Fatalf("unable to load model: %s", err.Error())
+	}
+
+	for _, year := range []float64{m.Epoch - 0.5, m.Epoch + validity, m.Epoch + 7.0} {
+		f, err := m.Evaluate(80.0, 0.0, 0.0, getDate(year))
+		if !errors.Is(err, ErrOutOfValidity) {
+			t.Errorf("%s at %.1f: got error %v, want %v", m.Name, year, err, ErrOutOfValidity)
+		}
+
+		// The extrapolated field is still returned
+		if f.Intensity == 0.0 || math.IsNaN(f.Intensity) {
+			t.Errorf("%s at %.1f: no extrapolated field", m.Name, year)
+		}
+	}
+}
+
+func TestNewModelFor(t *testing.T) {
+	models, err := getEmbeddedModels()
+	if err != nil {
+		t.Fatalf("unable to load models: %s", err.Error())
+	}
+
+	// Every release is chosen within its own validity period
+	for _, want := range models {
+		for _, year := range []float64{want.Epoch, want.Epoch + validity - 0.01} {
+			m, err := NewModelFor(getDate(year))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if m.Name != want.Name {
+				t.Errorf("model at %.2f: got %s, want %s", year, m.Name, want.Name)
+			}
+		}
+	}
+
+	// Past all of them the newest release extrapolates
+	newest := models[len(models)-1]
+	m, err := NewModelFor(getDate(newest.Epoch + 10.0))
+	if err != nil || m.Name != newest.Name {
+		t.Errorf("model after the last release: got %v, %v, want %s", m, err, newest.Name)
+	}
+}